@@ -0,0 +1,210 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/client/outbox"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// AgentCommand keeps a connection to the server open and relays messages from local processes
+type AgentCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *AgentCommand) Help() string {
+	help := `
+Usage: [flags] agent
+  Keeps a mutual TLS connection to the server open and relays messages sent by
+  local processes to the Unix socket set with the agent-socket option. Local
+  senders are acknowledged once the server has acknowledged their message, so
+  nothing is lost if the connection drops in between.
+
+  Only the current user may connect to the socket. Use send --via-agent to send
+  messages through a running agent.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *AgentCommand) Synopsis() string {
+	return "Relay messages from local processes over one connection"
+}
+
+// Run the actual command
+func (c *AgentCommand) Run(args []string) int {
+	path := cliUtils.GetAgentSocketPath()
+	listener, err := netUtils.ListenPrivateSocket(path)
+	if errors.Is(err, netUtils.ErrSocketInUse) {
+		c.UI.Error(fmt.Sprintf("An agent is already listening on %s", path))
+		return INTERNAL_ERROR
+	}
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	// both callbacks run on the outbox's connection goroutine, only the first failed
+	// attempt after losing the connection is logged
+	connected := false
+	a := &agent{routes: map[string]*agentClient{}}
+	a.box = newOutbox(outbox.Config{
+		OnConnect: func(conn io.ReadWriteCloser) {
+			connected = true
+			slog.Info("connected", "server", cliUtils.GetHostAndPort())
+		},
+		OnDisconnect: func(err error) {
+			if connected {
+				connected = false
+				slog.Warn("connection to the server lost, reconnecting", "error", err)
+			}
+		},
+		OnAck:    a.ack,
+		OnReject: a.reject,
+	})
+	defer a.box.Close()
+
+	// the socket file is removed when the listener closes
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		<-stop
+		close(stopped)
+		listener.Close()
+	}()
+
+	slog.Info("agent listening", "socket", path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stopped:
+			default:
+				c.UI.Error(err.Error())
+				return INTERNAL_ERROR
+			}
+			break
+		}
+		go a.serveLocal(conn)
+	}
+
+	if pending := a.box.Stats().Pending; pending > 0 {
+		c.UI.Warn(fmt.Sprintf("%d messages were not acknowledged by the server", pending))
+	}
+	return OK
+}
+
+// dialAgent is the dialer connecting to a local agent instead of the server
+func dialAgent() (io.ReadWriteCloser, error) {
+	return net.Dial("unix", cliUtils.GetAgentSocketPath())
+}
+
+// agent relays messages from local clients through its outbox and routes the server's
+// acknowledgements back to whoever sent the message
+type agent struct {
+	box *outbox.Outbox
+
+	mu     sync.Mutex
+	routes map[string]*agentClient
+}
+
+// agentClient is a local process connected to the agent socket
+type agentClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+func (c *agentClient) send(f *protocol.Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return protocol.WriteFrame(c.conn, f)
+}
+
+func (a *agent) serveLocal(conn net.Conn) {
+	defer conn.Close()
+	c := &agentClient{conn: conn}
+
+	reader := protocol.NewReader(conn)
+	for {
+		frame, err := protocol.ReadFrame(reader)
+		if err != nil {
+			if err != io.EOF {
+				slog.Warn("local read error", "error", err)
+			}
+			return
+		}
+
+		switch frame.Type {
+		case protocol.PingFrame:
+			c.send(protocol.NewPong(frame))
+		case protocol.PongFrame:
+		case protocol.MessageFrame:
+			// a local sender whose message can't be queued reconnects and resends it later
+			if err := a.relay(c, frame); err != nil {
+				slog.Warn("unable to relay message", "error", err)
+				c.send(protocol.NewError(err))
+				return
+			}
+		default:
+			c.send(protocol.NewError(fmt.Errorf("the agent only relays messages, not %s frames", frame.Type)))
+			return
+		}
+	}
+}
+
+// relay queues a message for the server, a message resent by a local client which
+// reconnected is only routed to the new connection as it is already queued
+func (a *agent) relay(c *agentClient, msg *protocol.Frame) error {
+	id := msg.Header(protocol.HeaderID)
+	if id != "" {
+		a.mu.Lock()
+		_, queued := a.routes[id]
+		a.routes[id] = c
+		a.mu.Unlock()
+		if queued {
+			return nil
+		}
+	}
+
+	if _, err := a.box.Send(msg); err != nil {
+		a.mu.Lock()
+		delete(a.routes, id)
+		a.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (a *agent) ack(id string) {
+	a.route(id, protocol.NewAck(id))
+}
+
+func (a *agent) reject(id string, reason string) {
+	reply := protocol.NewError(errors.New(reason))
+	reply.SetHeader(protocol.HeaderID, id)
+	a.route(id, reply)
+}
+
+// route hands the server's answer to a message to whoever sent it
+func (a *agent) route(id string, answer *protocol.Frame) {
+	a.mu.Lock()
+	c := a.routes[id]
+	delete(a.routes, id)
+	a.mu.Unlock()
+
+	if c != nil {
+		c.send(answer)
+	}
+}
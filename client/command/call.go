@@ -0,0 +1,122 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// CallCommand calls a method on the server and prints the response
+type CallCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *CallCommand) Help() string {
+	help := `
+Usage: [flags] call [options] method [payload | -]
+  Calls a method on the server with an optional payload and prints the response.
+  Passing - as the payload reads it from STDIN. The server provides echo and
+  time, other methods depend on the handlers it was built with.
+
+Options:
+  --timeout=d   How long to wait for the response, 30s by default.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *CallCommand) Synopsis() string {
+	return "Call a method on the server"
+}
+
+// Run the actual command
+func (c *CallCommand) Run(args []string) int {
+	var timeout time.Duration
+
+	cmdFlags := flag.NewFlagSet("call", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.DurationVar(&timeout, "timeout", 30*time.Second, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+	args = cmdFlags.Args()
+
+	if len(args) < 1 || len(args) > 2 {
+		slog.Error("Expected a method and an optional payload, run -h for more info")
+		return BAD_REQUEST
+	}
+
+	var payload []byte
+	if len(args) == 2 {
+		if args[1] == "-" {
+			var err error
+			if payload, err = ioutil.ReadAll(os.Stdin); err != nil {
+				c.UI.Error(err.Error())
+				return INTERNAL_ERROR
+			}
+		} else {
+			payload = []byte(args[1])
+		}
+	}
+
+	reply, err := call(args[0], payload, timeout)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	c.UI.Output(string(reply))
+	return OK
+}
+
+// call sends a single call over a new connection and waits for its reply
+func call(method string, payload []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := dialTLS()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// closing the connection unblocks the read below
+	timedOut := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		close(timedOut)
+		conn.Close()
+	})
+	defer timer.Stop()
+
+	const id = "1"
+	if err := protocol.WriteFrame(conn, protocol.NewCall(id, method, payload)); err != nil {
+		return nil, err
+	}
+
+	reader := protocol.NewReader(conn)
+	for {
+		frame, err := protocol.ReadFrame(reader)
+		if err != nil {
+			select {
+			case <-timedOut:
+				return nil, fmt.Errorf("No response to %s within %s", method, timeout)
+			default:
+				return nil, err
+			}
+		}
+		if frame.Header(protocol.HeaderID) != id {
+			continue
+		}
+
+		switch frame.Type {
+		case protocol.ReplyFrame:
+			return frame.Body, nil
+		case protocol.ErrorFrame:
+			return nil, errors.New(string(frame.Body))
+		}
+	}
+}
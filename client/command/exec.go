@@ -0,0 +1,103 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// ExecCommand runs an action configured on the server
+type ExecCommand struct {
+	UI cli.Ui
+}
+
+// execResult mirrors the result the server's exec actions answer with
+type execResult struct {
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated"`
+	TimedOut  bool   `json:"timed_out"`
+}
+
+// Long-form help
+func (c *ExecCommand) Help() string {
+	help := `
+Usage: [flags] exec [options] action [payload | -]
+  Runs an action configured on the server, passing the payload to it on STDIN.
+  Passing - as the payload reads it from STDIN. The action's output is written
+  to STDOUT and STDERR and its exit code becomes ours.
+
+Options:
+  --timeout=d   How long to wait for the action to finish, 10m by default. The
+                server enforces its own timeout for every action as well.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *ExecCommand) Synopsis() string {
+	return "Run an action configured on the server"
+}
+
+// Run the actual command
+func (c *ExecCommand) Run(args []string) int {
+	var timeout time.Duration
+
+	cmdFlags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.DurationVar(&timeout, "timeout", 10*time.Minute, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+	args = cmdFlags.Args()
+
+	if len(args) < 1 || len(args) > 2 {
+		slog.Error("Expected an action and an optional payload, run -h for more info")
+		return BAD_REQUEST
+	}
+
+	var payload []byte
+	if len(args) == 2 {
+		if args[1] == "-" {
+			var err error
+			if payload, err = ioutil.ReadAll(os.Stdin); err != nil {
+				c.UI.Error(err.Error())
+				return INTERNAL_ERROR
+			}
+		} else {
+			payload = []byte(args[1])
+		}
+	}
+
+	reply, err := call("exec."+args[0], payload, timeout)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	var result execResult
+	if err := json.Unmarshal(reply, &result); err != nil {
+		c.UI.Error("Invalid response from the server: " + err.Error())
+		return INTERNAL_ERROR
+	}
+
+	os.Stdout.WriteString(result.Stdout)
+	os.Stderr.WriteString(result.Stderr)
+	if result.Truncated {
+		c.UI.Warn("Output was truncated by the server")
+	}
+	if result.TimedOut {
+		c.UI.Error(fmt.Sprintf("%s timed out on the server", args[0]))
+		return INTERNAL_ERROR
+	}
+	if result.ExitCode < 0 {
+		return INTERNAL_ERROR
+	}
+	return result.ExitCode
+}
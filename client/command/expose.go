@@ -0,0 +1,157 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long the client waits for its local service to accept a relayed connection
+const exposeDialTimeout = 10 * time.Second
+
+// ExposeCommand makes a local service reachable on a port of the server
+type ExposeCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *ExposeCommand) Help() string {
+	help := `
+Usage: [flags] expose [options] --local=address --remote-port=port
+  Asks the server to listen on a port and relays every connection it accepts back
+  through the tunnel to a local service. Only outbound connections are made, so
+  this works from behind NAT. The server only allows ports listed for our identity.
+
+Options:
+  --local=address    Local service to relay connections to, e.g. 127.0.0.1:8080
+  --remote-port=n    Port the server should listen on, e.g. 9000
+  --mux              Relay every connection as a stream over one TLS connection
+                     instead of performing a handshake for each
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *ExposeCommand) Synopsis() string {
+	return "Expose a local service through a port on the server"
+}
+
+// Run the actual command
+func (c *ExposeCommand) Run(args []string) int {
+	var localAddr string
+	var remotePort int
+	var useMux bool
+
+	cmdFlags := flag.NewFlagSet("expose", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&localAddr, "local", "", "")
+	cmdFlags.IntVar(&remotePort, "remote-port", 0, "")
+	cmdFlags.BoolVar(&useMux, "mux", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+
+	if localAddr == "" || remotePort <= 0 || remotePort > 65535 {
+		slog.Error("--local and a valid --remote-port are required, run -h for more info")
+		return BAD_REQUEST
+	}
+
+	dial, session, err := newDialer(useMux)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if session != nil {
+		defer session.Close()
+	}
+
+	expose := &protocol.Frame{Type: protocol.ExposeFrame}
+	expose.SetHeader(protocol.HeaderPort, strconv.Itoa(remotePort))
+	announceHeartbeat(expose)
+	control, reader, err := openTunnel(dial, expose)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	defer control.Close()
+
+	var writeMu sync.Mutex
+	send := func(f *protocol.Frame) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return protocol.WriteFrame(control, f)
+	}
+
+	monitor := startHeartbeat(func() error {
+		return send(protocol.NewPing())
+	}, func() {
+		control.Close()
+	})
+	defer monitor.Stop()
+
+	c.UI.Info(fmt.Sprintf("Exposing %s on port %d of the server", localAddr, remotePort))
+
+	for {
+		frame, err := protocol.ReadFrame(reader)
+		if err != nil {
+			if err == io.EOF {
+				c.UI.Error("Connection closed by the server")
+			} else {
+				c.UI.Error(err.Error())
+			}
+			return INTERNAL_ERROR
+		}
+
+		switch frame.Type {
+		case protocol.ConnectFrame:
+			go relayConnection(dial, frame.Header(protocol.HeaderID), localAddr)
+		case protocol.PingFrame:
+			send(protocol.NewPong(frame))
+		case protocol.PongFrame:
+			monitor.Pong(frame.RTT())
+		case protocol.ErrorFrame:
+			c.UI.Error(string(frame.Body))
+		default:
+			slog.Warn("unexpected frame", "type", frame.Type.String())
+		}
+	}
+}
+
+// relayConnection claims a waiting inbound connection on a fresh tunnel and splices it to the local service
+func relayConnection(dial dialer, id string, localAddr string) {
+	local, err := net.DialTimeout("tcp", localAddr, exposeDialTimeout)
+	if err != nil {
+		slog.Warn("local service error", "error", err)
+		// claiming the connection anyway closes it on the server right away instead
+		// of leaving the remote peer waiting for the accept timeout
+		local = nil
+	}
+
+	accept := &protocol.Frame{Type: protocol.AcceptFrame}
+	accept.SetHeader(protocol.HeaderID, id)
+	conn, reader, err := openTunnel(dial, accept)
+	if err != nil {
+		slog.Warn("tunnel error", "error", err)
+		if local != nil {
+			local.Close()
+		}
+		return
+	}
+
+	if local == nil {
+		conn.Close()
+		return
+	}
+
+	slog.Info("relaying connection", "id", id, "local", localAddr)
+	netUtils.Splice(conn, reader, local)
+	slog.Info("closed connection", "id", id)
+}
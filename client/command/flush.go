@@ -0,0 +1,200 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/client/outbox"
+	"github.com/mattsurabian/go-tls/client/spool"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlushCommand delivers the messages send left in the spool
+type FlushCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *FlushCommand) Help() string {
+	help := `
+Usage: [flags] flush [options]
+  Delivers the messages waiting in the spool directory, oldest first. Each
+  message is removed from the spool once the server acknowledges it. Exits
+  with 0 when the spool is empty and 202 when messages are still queued.
+
+Options:
+  --interval=d  Keep running and drain the spool again every interval, for use
+                as a daemon.
+  --timeout=d   How long to wait for the server to acknowledge the messages,
+                30s by default.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *FlushCommand) Synopsis() string {
+	return "Deliver messages queued in the spool"
+}
+
+// Run the actual command
+func (c *FlushCommand) Run(args []string) int {
+	var interval, timeout time.Duration
+
+	cmdFlags := flag.NewFlagSet("flush", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.DurationVar(&interval, "interval", 0, "")
+	cmdFlags.DurationVar(&timeout, "timeout", 30*time.Second, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+
+	sp, err := openSpool()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if sp == nil {
+		c.UI.Error("No spool-dir configured")
+		return BAD_REQUEST
+	}
+
+	for {
+		before, err := sp.Len()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+
+		remaining, err := drainSpool(sp, timeout)
+		if before > 0 {
+			slog.Info("delivered spooled messages", "delivered", before-remaining, "spooled", before)
+		}
+
+		if interval == 0 {
+			if remaining == 0 {
+				return OK
+			}
+			c.UI.Warn(fmt.Sprintf("Server unreachable (%v), %d messages still queued", err, remaining))
+			return QUEUED
+		}
+
+		if remaining > 0 {
+			slog.Warn("messages still queued", "remaining", remaining, "error", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// openSpool opens the configured spool directory, or returns nil when there is none
+func openSpool() (*spool.Spool, error) {
+	dir := cliUtils.GetSpoolDir()
+	if dir == "" {
+		return nil, nil
+	}
+	return spool.Open(dir, cliUtils.GetSpoolMaxSize())
+}
+
+// drainSpool sends every spooled message in order and removes those the server
+// acknowledges. It returns the number of messages left and, if any, why.
+func drainSpool(sp *spool.Spool, timeout time.Duration) (int, error) {
+	entries, err := sp.Entries()
+	if err != nil || len(entries) == 0 {
+		return len(entries), err
+	}
+
+	var mu sync.Mutex
+	byID := map[string]spool.Entry{}
+	box := newOutbox(outbox.Config{
+		MaxAttempts: sendDialAttempts,
+		MaxPending:  sendWindow,
+		OnAck: func(id string) {
+			mu.Lock()
+			e, ok := byID[id]
+			delete(byID, id)
+			mu.Unlock()
+			if ok {
+				if err := sp.Remove(e); err != nil {
+					slog.Error("spool", "error", err)
+				}
+			}
+		},
+		// a rejected message would be rejected again, set it aside for inspection
+		OnReject: func(id string, reason string) {
+			mu.Lock()
+			e, ok := byID[id]
+			delete(byID, id)
+			mu.Unlock()
+			if ok {
+				slog.Warn("spool: message rejected by the server", "name", e.Name, "reason", reason)
+				if err := sp.Reject(e); err != nil {
+					slog.Error("spool", "error", err)
+				}
+			}
+		},
+	})
+
+	err = sendEntries(box, sp, entries, timeout, func(id string, e spool.Entry) {
+		mu.Lock()
+		byID[id] = e
+		mu.Unlock()
+	})
+	if err == nil {
+		err = box.Flush(timeout)
+	}
+	// closing waits for the read loop, so every acknowledgement has been handled after it
+	box.Close()
+
+	remaining, lenErr := sp.Len()
+	if lenErr != nil {
+		return remaining, lenErr
+	}
+	if remaining > 0 && err == nil {
+		err = errors.New("new messages were spooled while draining")
+	}
+	return remaining, err
+}
+
+// sendEntries hands spooled messages to box, calling track with the id of each before
+// it is sent so its acknowledgement can be matched to the file
+func sendEntries(box *outbox.Outbox, sp *spool.Spool, entries []spool.Entry, timeout time.Duration, track func(string, spool.Entry)) error {
+	for _, e := range entries {
+		msg, err := sp.Read(e)
+		if os.IsNotExist(err) {
+			// delivered by another flush in the meantime
+			continue
+		}
+		if err != nil {
+			if err := sp.Reject(e); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// messages spooled before they were ever sent are named after their file, which
+		// is just as unique
+		if msg.Header(protocol.HeaderID) == "" {
+			msg.SetHeader(protocol.HeaderID, e.Name)
+		}
+		track(msg.Header(protocol.HeaderID), e)
+
+		for {
+			_, err := box.Send(msg)
+			if err != outbox.ErrFull {
+				if err != nil {
+					return err
+				}
+				break
+			}
+			if err := box.Flush(timeout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
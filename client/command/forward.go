@@ -0,0 +1,111 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// ForwardCommand accepts local plaintext connections and carries them to a target through the server
+type ForwardCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *ForwardCommand) Help() string {
+	help := `
+Usage: [flags] forward [options] --listen=address --target=address
+  Listens for plaintext TCP connections on a local address and carries each one
+  over a mutual-TLS connection to the server, which dials the target on our behalf.
+
+Options:
+  --listen=address   Local address to accept connections on, e.g. 127.0.0.1:5432
+  --target=address   Address the server should dial, e.g. db:5432
+  --mux              Carry every connection as a stream over one TLS connection
+                     instead of performing a handshake for each
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *ForwardCommand) Synopsis() string {
+	return "Forward a local port to a target through the server"
+}
+
+// Run the actual command
+func (c *ForwardCommand) Run(args []string) int {
+	var listenAddr, target string
+	var useMux bool
+
+	cmdFlags := flag.NewFlagSet("forward", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&listenAddr, "listen", "", "")
+	cmdFlags.StringVar(&target, "target", "", "")
+	cmdFlags.BoolVar(&useMux, "mux", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+
+	if listenAddr == "" || target == "" {
+		slog.Error("Both --listen and --target are required, run -h for more info")
+		return BAD_REQUEST
+	}
+
+	dial, session, err := newDialer(useMux)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	defer listener.Close()
+
+	if session != nil {
+		defer session.Close()
+		// without the shared connection there is nothing left to forward over
+		go func() {
+			<-session.Closed()
+			listener.Close()
+		}()
+	}
+
+	c.UI.Info(fmt.Sprintf("Forwarding %s to %s through the server", listener.Addr(), target))
+
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			if session != nil && session.Err() != nil {
+				c.UI.Error("Connection to the server lost: " + session.Err().Error())
+			} else {
+				c.UI.Error(err.Error())
+			}
+			return INTERNAL_ERROR
+		}
+		go forwardConnection(dial, local, target)
+	}
+}
+
+// forwardConnection opens a tunnel for a single local connection and splices the two together
+func forwardConnection(dial dialer, local net.Conn, target string) {
+	slog.Info("forwarding", "local", local.RemoteAddr().String(), "target", target)
+
+	open := &protocol.Frame{Type: protocol.OpenFrame}
+	open.SetHeader(protocol.HeaderTarget, target)
+	conn, reader, err := openTunnel(dial, open)
+	if err != nil {
+		slog.Warn("tunnel failed", "target", target, "error", err)
+		local.Close()
+		return
+	}
+
+	netUtils.Splice(conn, reader, local)
+	slog.Info("closed", "local", local.RemoteAddr().String())
+}
@@ -0,0 +1,25 @@
+package command
+
+import (
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/heartbeat"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"log/slog"
+)
+
+// announceHeartbeat tells the server we answer pings, so it may ping us in turn
+func announceHeartbeat(first *protocol.Frame) {
+	if interval := cliUtils.GetHeartbeatInterval(); interval > 0 {
+		first.SetHeader(protocol.HeaderHeartbeat, interval.String())
+	}
+}
+
+// startHeartbeat pings the server at the configured interval and calls onDead once it
+// stops answering, it returns nil when heartbeats are disabled
+func startHeartbeat(ping func() error, onDead func()) *heartbeat.Monitor {
+	misses := cliUtils.GetHeartbeatMisses()
+	return heartbeat.Start(cliUtils.GetHeartbeatInterval(), misses, ping, func() {
+		slog.Warn("heartbeat missed, closing connection", "misses", misses)
+		onDead()
+	})
+}
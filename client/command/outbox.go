@@ -0,0 +1,18 @@
+package command
+
+import (
+	"github.com/mattsurabian/go-tls/client/outbox"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+)
+
+// newOutbox creates an outbox delivering messages over their own TLS connection, unless
+// cfg names another dialer, with the configured heartbeats. cfg supplies the callbacks
+// and retry limits.
+func newOutbox(cfg outbox.Config) *outbox.Outbox {
+	if cfg.Dial == nil {
+		cfg.Dial = dialTLS
+	}
+	cfg.HeartbeatInterval = cliUtils.GetHeartbeatInterval()
+	cfg.HeartbeatMisses = cliUtils.GetHeartbeatMisses()
+	return outbox.New(cfg)
+}
@@ -0,0 +1,160 @@
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PullCommand downloads a file from the server's file directory
+type PullCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *PullCommand) Help() string {
+	help := `
+Usage: [flags] pull [options] remote-name local-path
+  Downloads a file from the server's file directory. Data is written to
+  local-path.part first and only renamed to local-path once its SHA-256 matches
+  the server's, an existing .part file is resumed rather than downloaded again.
+
+Options:
+  --retries=n   How many times to resume after the connection fails, 5 by default.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *PullCommand) Synopsis() string {
+	return "Download a file from the server"
+}
+
+// Run the actual command
+func (c *PullCommand) Run(args []string) int {
+	var retries int
+
+	cmdFlags := flag.NewFlagSet("pull", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.IntVar(&retries, "retries", 5, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+	args = cmdFlags.Args()
+
+	if len(args) != 2 {
+		slog.Error("Expected a remote name and a local path, run -h for more info")
+		return BAD_REQUEST
+	}
+	name, localPath := args[0], args[1]
+
+	var size int64
+	var sum string
+	err := withRetries(retries, func() error {
+		var offset int64
+		var err error
+		size, sum, offset, err = pullFile(name, localPath)
+		if offset > 0 {
+			c.UI.Info(fmt.Sprintf("Resumed %s at %d of %d bytes", name, offset, size))
+		}
+		return err
+	})
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	c.UI.Output(fmt.Sprintf("Pulled %s, %d bytes, sha256 %s", name, size, sum))
+	return OK
+}
+
+// pullFile downloads name into localPath.part from wherever it ends, then verifies it
+// and moves it into place. It returns the file's size, checksum and the resumed offset.
+func pullFile(name string, localPath string) (int64, string, int64, error) {
+	partPath := localPath + ".part"
+	part, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer part.Close()
+
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	pull := &protocol.Frame{Type: protocol.PullFrame}
+	pull.SetHeader(protocol.HeaderFilename, name)
+	pull.SetHeader(protocol.HeaderOffset, strconv.FormatInt(offset, 10))
+
+	conn, reader, opened, err := requestTunnel(dialTLS, pull)
+	if err != nil {
+		if offset == 0 {
+			os.Remove(partPath)
+		}
+		return 0, "", 0, err
+	}
+	defer conn.Close()
+
+	size, sizeErr := strconv.ParseInt(opened.Header(protocol.HeaderSize), 10, 64)
+	start, offsetErr := strconv.ParseInt(opened.Header(protocol.HeaderOffset), 10, 64)
+	sum := opened.Header(protocol.HeaderSHA256)
+	if sizeErr != nil || offsetErr != nil || start < 0 || start > size {
+		return 0, "", 0, remoteError("invalid reply from the server")
+	}
+
+	// the server starts over when our partial copy is longer than its file
+	if start != offset {
+		if err := part.Truncate(start); err != nil {
+			return size, sum, 0, err
+		}
+		if _, err := part.Seek(start, io.SeekStart); err != nil {
+			return size, sum, 0, err
+		}
+	}
+
+	_, err = protocol.ReceiveData(reader, part, size-start)
+	if syncErr := part.Sync(); err == nil {
+		err = syncErr
+	}
+	if err != nil {
+		return size, sum, start, err
+	}
+
+	verify, err := os.Open(partPath)
+	if err != nil {
+		return size, sum, start, err
+	}
+	actual, err := checksumFile(verify)
+	verify.Close()
+	if err != nil {
+		return size, sum, start, err
+	}
+	if actual != sum {
+		// most likely the file changed on the server since the partial download began
+		os.Remove(partPath)
+		return size, sum, start, errors.New("checksum mismatch, the partial download was discarded")
+	}
+
+	return size, sum, start, os.Rename(partPath, localPath)
+}
+
+// checksumFile returns the hex encoded SHA-256 of f's contents
+func checksumFile(f *os.File) (string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PushCommand uploads a file to the server's file directory
+type PushCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *PushCommand) Help() string {
+	help := `
+Usage: [flags] push [options] local-path remote-name
+  Uploads a file to the server's file directory under remote-name, which may
+  contain / to place it in a subdirectory. The file is sent in chunks and its
+  SHA-256 is verified by the server before it replaces an existing file.
+
+  An interrupted upload is resumed from where the server stopped receiving, both
+  within the retries of one push and when the same file is pushed again later.
+
+Options:
+  --retries=n   How many times to resume after the connection fails, 5 by default.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *PushCommand) Synopsis() string {
+	return "Upload a file to the server"
+}
+
+// Run the actual command
+func (c *PushCommand) Run(args []string) int {
+	var retries int
+
+	cmdFlags := flag.NewFlagSet("push", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.IntVar(&retries, "retries", 5, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+	args = cmdFlags.Args()
+
+	if len(args) != 2 {
+		slog.Error("Expected a local path and a remote name, run -h for more info")
+		return BAD_REQUEST
+	}
+	localPath, name := args[0], args[1]
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return BAD_REQUEST
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	sum, err := checksumFile(f)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	err = withRetries(retries, func() error {
+		offset, err := pushFile(f, name, info.Size(), sum)
+		if offset > 0 {
+			c.UI.Info(fmt.Sprintf("Resumed %s at %d of %d bytes", name, offset, info.Size()))
+		}
+		return err
+	})
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	c.UI.Output(fmt.Sprintf("Pushed %s, %d bytes, sha256 %s", name, info.Size(), sum))
+	return OK
+}
+
+// pushFile sends f from wherever the server's partial copy ends and waits for the server
+// to verify it. It returns the offset the upload resumed at.
+func pushFile(f *os.File, name string, size int64, sum string) (int64, error) {
+	push := &protocol.Frame{Type: protocol.PushFrame}
+	push.SetHeader(protocol.HeaderFilename, name)
+	push.SetHeader(protocol.HeaderSize, strconv.FormatInt(size, 10))
+	push.SetHeader(protocol.HeaderSHA256, sum)
+
+	conn, reader, opened, err := requestTunnel(dialTLS, push)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	offset, err := strconv.ParseInt(opened.Header(protocol.HeaderOffset), 10, 64)
+	if err != nil || offset < 0 || offset > size {
+		return 0, remoteError("invalid offset from the server")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	if _, err := protocol.SendData(conn, io.LimitReader(f, size-offset)); err != nil {
+		return offset, err
+	}
+
+	reply, err := protocol.ReadFrame(reader)
+	if err != nil {
+		return offset, err
+	}
+	switch reply.Type {
+	case protocol.AckFrame:
+		return offset, nil
+	case protocol.ErrorFrame:
+		return offset, remoteError(reply.Body)
+	default:
+		return offset, fmt.Errorf("unexpected %s frame", reply.Type)
+	}
+}
+
+// withRetries runs transfer until it succeeds, the server refuses it or it failed
+// retries more times, waiting a little longer after every failure
+func withRetries(retries int, transfer func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := transfer()
+		var refused remoteError
+		if err == nil || errors.As(err, &refused) || attempt >= retries {
+			return err
+		}
+		slog.Warn("transfer interrupted, retrying", "error", err)
+		time.Sleep(time.Duration(attempt+1) * time.Second)
+	}
+}
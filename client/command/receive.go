@@ -0,0 +1,58 @@
+package command
+
+import (
+	"flag"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"log/slog"
+	"strings"
+)
+
+// ReceiveCommand prints the messages other clients send to us directly
+type ReceiveCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *ReceiveCommand) Help() string {
+	help := `
+Usage: [flags] receive [options]
+  Makes us reachable for direct messages and writes every message other clients
+  send to us with send --to to STDOUT, one per line, until interrupted. Clients
+  address us by the identity on our certificate, e.g. CN=Client0.
+
+  Messages sent while we aren't receiving are refused, unless the server holds
+  them for offline clients, in which case they are written first.
+
+Options:
+  --from      Prefix every message with the identity of the client that sent it.
+  --count=n   Exit after receiving n messages.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *ReceiveCommand) Synopsis() string {
+	return "Receive messages sent directly to us by other clients"
+}
+
+// Run the actual command
+func (c *ReceiveCommand) Run(args []string) int {
+	var showFrom bool
+	var count int
+
+	cmdFlags := flag.NewFlagSet("receive", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.BoolVar(&showFrom, "from", false, "")
+	cmdFlags.IntVar(&count, "count", 0, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+
+	if len(cmdFlags.Args()) != 0 {
+		slog.Error("Unexpected arguments, run -h for more info")
+		return BAD_REQUEST
+	}
+
+	receive := &protocol.Frame{Type: protocol.ReceiveFrame}
+	return readMessages(c.UI, receive, "receiving direct messages", showFrom, count)
+}
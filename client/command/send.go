@@ -1,11 +1,20 @@
 package command
 
 import (
-	"github.com/mattsurabian/go-tls/shared/tlsUtils"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/client/outbox"
+	"github.com/mattsurabian/go-tls/client/spool"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
 	"github.com/mitchellh/cli"
-
-	"log"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 // SendCommand sends data to the server
@@ -16,7 +25,26 @@ type SendCommand struct {
 // Long-form help
 func (c *SendCommand) Help() string {
 	help := `
-Usage: [flags] send [text]
+Usage: [flags] send [options] [text | -]
+  Sends text, the contents of files or STDIN to the server. Each text argument
+  is sent as its own message, passing - instead reads from STDIN until EOF.
+
+Options:
+  --file=path   Send the contents of a file, may be repeated and may be a glob.
+                The file name is sent along with the contents as metadata.
+  --lines       Send every line of input as a separate message.
+  --topic=name  Publish the messages to a topic, the server delivers them to
+                every client subscribed to it.
+  --to=identity Send the messages directly to another client, e.g. --to=CN=sensor-12.
+                It has to be running receive, unless the server holds messages
+                for offline clients.
+  --timeout=d   How long to wait for the server to acknowledge every message,
+                30s by default. Messages are resent if the connection drops.
+  --via-agent   Send through a running client agent, connecting directly when
+                no agent is listening on the agent-socket.
+
+When the spool-dir option is set, messages which can't be delivered are written
+to the spool instead and the command exits with 202. Run flush to deliver them.
 `
 	return strings.TrimSpace(help)
 }
@@ -27,20 +55,268 @@ func (c *SendCommand) Synopsis() string {
 
 // Run the actual command
 func (c *SendCommand) Run(args []string) int {
+	var files stringSliceFlag
+	var lines bool
+	var timeout time.Duration
+	var viaAgent bool
+	var topic string
+	var to string
+
+	cmdFlags := flag.NewFlagSet("send", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.Var(&files, "file", "")
+	cmdFlags.BoolVar(&lines, "lines", false, "")
+	cmdFlags.DurationVar(&timeout, "timeout", 30*time.Second, "")
+	cmdFlags.BoolVar(&viaAgent, "via-agent", false, "")
+	cmdFlags.StringVar(&topic, "topic", "", "")
+	cmdFlags.StringVar(&to, "to", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+	args = cmdFlags.Args()
 
-	if len(args) < 1 {
-		log.Println("Error: Missing arguments, run -h for more info")
+	if len(args) < 1 && len(files) < 1 {
+		slog.Error("Missing arguments, run -h for more info")
 		return BAD_REQUEST
 	}
 
-	textToSend := []byte(args[0])
+	if topic != "" && to != "" {
+		slog.Error("--topic and --to can't be combined, run -h for more info")
+		return BAD_REQUEST
+	}
 
-	conn, err := tlsUtils.GetClientTLSConnection()
+	paths, err := expandFileGlobs(files)
 	if err != nil {
 		c.UI.Error(err.Error())
-	} else {
-		conn.Write(textToSend)
+		return BAD_REQUEST
+	}
+
+	sp, err := openSpool()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	q := &sendQueue{spool: sp, timeout: timeout, topic: topic, to: to}
+	if viaAgent {
+		if conn, err := dialAgent(); err == nil {
+			conn.Close()
+			q.dial = dialAgent
+		} else {
+			c.UI.Warn("No agent listening on " + cliUtils.GetAgentSocketPath() + ", connecting directly")
+		}
+	}
+	defer q.close()
+
+	// messages already waiting in the spool must be delivered first, so new ones queue
+	// up behind them and the whole spool is drained at the end
+	if sp != nil {
+		if n, err := sp.Len(); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		} else if n > 0 {
+			q.spooled = true
+		}
+	}
+
+	for _, arg := range args {
+		if arg == "-" {
+			err = sendReader(q, os.Stdin, nil, lines)
+		} else if lines {
+			err = sendReader(q, strings.NewReader(arg), nil, true)
+		} else {
+			err = q.send(protocol.NewMessage([]byte(arg)))
+		}
+		if err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+	}
+
+	for _, path := range paths {
+		if err := sendFile(q, path, lines); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+	}
+
+	if err := q.finish(); err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if n := q.rejectedCount(); n > 0 {
+		c.UI.Error(fmt.Sprintf("%d messages were rejected by the server", n))
+		return BAD_REQUEST
+	}
+	if !q.spooled {
+		return OK
+	}
+
+	remaining, err := drainSpool(sp, timeout)
+	if remaining == 0 {
+		return OK
+	}
+	c.UI.Warn(fmt.Sprintf("Server unreachable (%v), %d messages queued in %s", err, remaining, sp.Dir()))
+	return QUEUED
+}
+
+const (
+	// sendDialAttempts is how many times send tries to reach the server before giving up
+	sendDialAttempts = 5
+
+	// sendWindow bounds how many messages may be awaiting acknowledgement at once
+	sendWindow = 256
+)
+
+// sendQueue hands messages to an outbox, waiting for acknowledgements whenever the
+// outbox is full so large inputs aren't buffered in memory. When the server can't be
+// reached and a spool is configured, everything not yet acknowledged goes to the spool.
+type sendQueue struct {
+	dial    dialer
+	box     *outbox.Outbox
+	spool   *spool.Spool
+	spooled bool
+	timeout time.Duration
+	topic   string
+	to      string
+
+	mu       sync.Mutex
+	rejected int
+}
+
+func (q *sendQueue) send(msg *protocol.Frame) error {
+	if q.topic != "" {
+		msg.SetHeader(protocol.HeaderTopic, q.topic)
+	}
+	if q.to != "" {
+		msg.SetHeader(protocol.HeaderTo, q.to)
+	}
+	if q.spooled {
+		return q.spool.Enqueue(msg)
+	}
+	if q.box == nil {
+		q.box = newOutbox(outbox.Config{
+			Dial:        q.dial,
+			MaxAttempts: sendDialAttempts,
+			MaxPending:  sendWindow,
+			OnReject:    q.reject,
+		})
+	}
+
+	for {
+		_, err := q.box.Send(msg)
+		if err == nil {
+			return nil
+		}
+		if err == outbox.ErrFull {
+			if err = q.box.Flush(q.timeout); err == nil {
+				continue
+			}
+		}
+		return q.fallback(err, msg)
+	}
+}
+
+// finish waits for every message to be acknowledged, spooling them if that fails
+func (q *sendQueue) finish() error {
+	if q.spooled || q.box == nil {
+		return nil
+	}
+	if err := q.box.Flush(q.timeout); err != nil {
+		return q.fallback(err)
 	}
+	return nil
+}
+
+// fallback moves the unacknowledged messages and rest to the spool, or returns err when
+// there is no spool. Messages keep their ids so the server can drop any which did arrive.
+func (q *sendQueue) fallback(err error, rest ...*protocol.Frame) error {
+	if q.spool == nil {
+		if q.box != nil {
+			return fmt.Errorf("%d messages not acknowledged: %v", len(q.box.Unacknowledged())+len(rest), err)
+		}
+		return err
+	}
+
+	frames := append(q.box.Unacknowledged(), rest...)
+	q.box.Close()
+	for _, f := range frames {
+		if err := q.spool.Enqueue(f); err != nil {
+			return err
+		}
+	}
+	q.spooled = true
+	return nil
+}
+
+// reject records a message the server refused, it won't be resent or spooled
+func (q *sendQueue) reject(id string, reason string) {
+	slog.Warn("message rejected", "id", id, "reason", reason)
+	q.mu.Lock()
+	q.rejected++
+	q.mu.Unlock()
+}
+
+func (q *sendQueue) rejectedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.rejected
+}
+
+func (q *sendQueue) close() {
+	if q.box != nil {
+		q.box.Close()
+	}
+}
+
+// stringSliceFlag is a flag.Value which collects every occurrence of a repeated flag
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// expandFileGlobs resolves every --file value to the list of files it matches
+func expandFileGlobs(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid file pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match %q", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+func sendFile(q *sendQueue, path string, lines bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	headers := map[string]string{
+		protocol.HeaderFilename: filepath.Base(path),
+	}
+	return sendReader(q, f, headers, lines)
+}
 
-	return OK
+// sendReader sends everything read from r either line by line or in chunks of
+// protocol.ChunkSize, each message carrying a copy of the given headers
+func sendReader(q *sendQueue, r io.Reader, headers map[string]string, lines bool) error {
+	return protocol.SplitMessages(r, lines, func(msg *protocol.Frame) error {
+		for k, v := range headers {
+			msg.SetHeader(k, v)
+		}
+		return q.send(msg)
+	})
 }
@@ -0,0 +1,243 @@
+package command
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"github.com/mattsurabian/go-tls/client/outbox"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionCommand keeps a single connection to the server open and sends each line typed
+type SessionCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *SessionCommand) Help() string {
+	help := `
+Usage: [flags] session
+  Opens an interactive session over a single TLS connection. Every line entered
+  is sent to the server as a message and acknowledgements are printed inline.
+  When the connection is lost the session reconnects by itself and resends
+  every message the server hasn't acknowledged yet.
+
+Session commands:
+  /status      Show the state of the connection
+  /reconnect   Drop the connection and establish a new one
+  /history     List previously sent lines, !! resends the last one and !n resends line n
+  /quit        Close the connection and exit
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *SessionCommand) Synopsis() string {
+	return "Open an interactive session with the server"
+}
+
+// Run the actual command
+func (c *SessionCommand) Run(args []string) int {
+	s := &session{
+		ui:        &cli.ConcurrentUi{Ui: c.UI},
+		connected: make(chan struct{}),
+	}
+
+	s.outbox = newOutbox(outbox.Config{
+		OnConnect:    s.onConnect,
+		OnDisconnect: s.onDisconnect,
+		OnAck: func(id string) {
+			s.ui.Output("ack #" + id)
+		},
+		OnFrame: func(f *protocol.Frame) {
+			s.ui.Output(fmt.Sprintf("%s: %s", f.Type, f.Body))
+		},
+	})
+	defer s.outbox.Close()
+
+	// the first connection is made here so an unreachable server is reported right away,
+	// later failures are retried in the background
+	select {
+	case <-s.connected:
+	case <-time.After(10 * time.Second):
+		s.mu.Lock()
+		err := s.dialErr
+		s.mu.Unlock()
+		c.UI.Error(fmt.Sprintf("Unable to connect to %s: %v", cliUtils.GetHostAndPort(), err))
+		return INTERNAL_ERROR
+	}
+
+	s.ui.Info("Connected to " + cliUtils.GetHostAndPort() + ", type /help for a list of commands")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stdout, "> ")
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		line, ok := s.expandHistory(line)
+		if !ok {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			if quit := s.runSlashCommand(line); quit {
+				break
+			}
+			continue
+		}
+
+		s.send(line)
+	}
+
+	if pending := s.outbox.Stats().Pending; pending > 0 {
+		s.ui.Warn(fmt.Sprintf("%d messages were not acknowledged", pending))
+	}
+	return OK
+}
+
+// session tracks the state of SessionCommand, the outbox reconnects whenever the
+// connection is lost and resends what the server hasn't acknowledged
+type session struct {
+	ui     cli.Ui
+	outbox *outbox.Outbox
+
+	mu          sync.Mutex
+	connected   chan struct{}
+	connectedAt time.Time
+	lost        bool
+	dialErr     error
+	history     []string
+}
+
+func (s *session) onConnect(conn io.ReadWriteCloser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connectedAt.IsZero() {
+		close(s.connected)
+	} else if s.lost {
+		s.ui.Info("Reconnected to " + cliUtils.GetHostAndPort())
+	}
+	s.connectedAt = time.Now()
+	s.lost = false
+}
+
+func (s *session) onDisconnect(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connectedAt.IsZero() {
+		s.dialErr = err
+		return
+	}
+	// only report the first failure, the outbox keeps retrying quietly
+	if s.lost {
+		return
+	}
+	s.lost = true
+	if err == io.EOF {
+		s.ui.Warn("Connection closed by the server, reconnecting")
+	} else {
+		s.ui.Warn("Connection lost: " + err.Error() + ", reconnecting")
+	}
+}
+
+func (s *session) send(line string) {
+	s.mu.Lock()
+	s.history = append(s.history, line)
+	s.mu.Unlock()
+
+	if _, err := s.outbox.Send(protocol.NewMessage([]byte(line))); err != nil {
+		s.ui.Error("Send failed: " + err.Error())
+	}
+}
+
+// expandHistory replaces !! and !n with the matching history entry
+func (s *session) expandHistory(line string) (string, bool) {
+	if !strings.HasPrefix(line, "!") {
+		return line, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if line == "!!" {
+		if len(s.history) == 0 {
+			s.ui.Error("History is empty")
+			return "", false
+		}
+		return s.history[len(s.history)-1], true
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(s.history) {
+		s.ui.Error("No such history entry: " + line)
+		return "", false
+	}
+	return s.history[n-1], true
+}
+
+// runSlashCommand executes a session command and reports whether the session should end
+func (s *session) runSlashCommand(line string) bool {
+	switch strings.Fields(line)[0] {
+	case "/quit", "/exit":
+		return true
+	case "/status":
+		s.printStatus()
+	case "/reconnect":
+		s.mu.Lock()
+		s.lost = true
+		s.mu.Unlock()
+		s.outbox.Reconnect()
+	case "/history":
+		s.mu.Lock()
+		for i, entry := range s.history {
+			s.ui.Output(fmt.Sprintf("%4d  %s", i+1, entry))
+		}
+		s.mu.Unlock()
+	case "/help":
+		s.ui.Output((&SessionCommand{}).Help())
+	default:
+		s.ui.Error("Unknown command " + line + ", type /help for a list of commands")
+	}
+	return false
+}
+
+func (s *session) printStatus() {
+	stats := s.outbox.Stats()
+	conn, ok := stats.Conn.(*tls.Conn)
+
+	if !stats.Connected || !ok {
+		s.ui.Output("state:     reconnecting")
+	} else {
+		state := conn.ConnectionState()
+		s.mu.Lock()
+		uptime := time.Since(s.connectedAt)
+		s.mu.Unlock()
+
+		s.ui.Output("state:     connected")
+		s.ui.Output("server:    " + conn.RemoteAddr().String())
+		s.ui.Output("tls:       " + tls.VersionName(state.Version) + ", " + tls.CipherSuiteName(state.CipherSuite))
+		s.ui.Output("uptime:    " + uptime.Round(time.Second).String())
+		if stats.Heartbeat.Pongs > 0 {
+			s.ui.Output(fmt.Sprintf("rtt:       %s last, %s average",
+				stats.Heartbeat.LastRTT.Round(time.Microsecond), stats.Heartbeat.AvgRTT.Round(time.Microsecond)))
+		}
+	}
+	s.ui.Output(fmt.Sprintf("messages:  %d sent, %d acknowledged, %d pending, %d resent",
+		stats.Sent, stats.Acked, stats.Pending, stats.Resent))
+}
@@ -0,0 +1,141 @@
+package command
+
+import (
+	"bytes"
+	"flag"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SubscribeCommand prints every message published to a topic
+type SubscribeCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *SubscribeCommand) Help() string {
+	help := `
+Usage: [flags] subscribe [options] topic
+  Subscribes to a topic and writes every message published to it to STDOUT, one
+  per line, until interrupted. Messages are published by other clients with
+  send --topic. The server only allows topics listed for our identity.
+
+  The server queues a limited number of messages for a subscriber which reads
+  slowly, depending on its configuration it then drops the oldest messages, which
+  is reported on STDERR, or disconnects the subscriber.
+
+Options:
+  --from      Prefix every message with the identity of the client that published it.
+  --count=n   Exit after receiving n messages.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *SubscribeCommand) Synopsis() string {
+	return "Receive the messages published to a topic"
+}
+
+// Run the actual command
+func (c *SubscribeCommand) Run(args []string) int {
+	var showFrom bool
+	var count int
+
+	cmdFlags := flag.NewFlagSet("subscribe", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.BoolVar(&showFrom, "from", false, "")
+	cmdFlags.IntVar(&count, "count", 0, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+	args = cmdFlags.Args()
+
+	if len(args) != 1 {
+		slog.Error("Expected a topic, run -h for more info")
+		return BAD_REQUEST
+	}
+	topic := args[0]
+
+	subscribe := &protocol.Frame{Type: protocol.SubscribeFrame}
+	subscribe.SetHeader(protocol.HeaderTopic, topic)
+	return readMessages(c.UI, subscribe, "subscribed to "+topic, showFrom, count)
+}
+
+// readMessages opens a conversation with request and prints the messages the server
+// delivers on it, until count messages arrived or, when count is zero, forever
+func readMessages(ui cli.Ui, request *protocol.Frame, opened string, showFrom bool, count int) int {
+	announceHeartbeat(request)
+	conn, reader, err := openTunnel(dialTLS, request)
+	if err != nil {
+		ui.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(f *protocol.Frame) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return protocol.WriteFrame(conn, f)
+	}
+
+	monitor := startHeartbeat(func() error {
+		return send(protocol.NewPing())
+	}, func() {
+		conn.Close()
+	})
+	defer monitor.Stop()
+
+	slog.Info(opened)
+
+	received := 0
+	for count == 0 || received < count {
+		frame, err := protocol.ReadFrame(reader)
+		if err != nil {
+			if err == io.EOF {
+				ui.Error("Connection closed by the server")
+			} else {
+				ui.Error(err.Error())
+			}
+			return INTERNAL_ERROR
+		}
+
+		switch frame.Type {
+		case protocol.MessageFrame:
+			if dropped := frame.Header(protocol.HeaderDropped); dropped != "" {
+				// logged rather than printed so it stays out of the messages on STDOUT
+				slog.Warn("messages were dropped because we fell behind", "dropped", dropped)
+			}
+			printMessage(frame, showFrom)
+			received++
+		case protocol.PingFrame:
+			send(protocol.NewPong(frame))
+		case protocol.PongFrame:
+			monitor.Pong(frame.RTT())
+		case protocol.ErrorFrame:
+			ui.Error(string(frame.Body))
+			return INTERNAL_ERROR
+		default:
+			slog.Warn("unexpected frame", "type", frame.Type.String())
+		}
+	}
+	return OK
+}
+
+// printMessage writes a delivered message to STDOUT on a line of its own
+func printMessage(msg *protocol.Frame, showFrom bool) {
+	var line bytes.Buffer
+	if showFrom {
+		line.WriteString(msg.Header(protocol.HeaderFrom))
+		line.WriteString(": ")
+	}
+	line.Write(msg.Body)
+	if !bytes.HasSuffix(msg.Body, []byte("\n")) {
+		line.WriteByte('\n')
+	}
+	os.Stdout.Write(line.Bytes())
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/mux"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mattsurabian/go-tls/shared/tlsUtils"
+	"io"
+)
+
+// dialer opens a new conversation with the server, either a TLS connection of its own
+// or a stream multiplexed over a shared one
+type dialer func() (io.ReadWriteCloser, error)
+
+// dialTLS is the dialer establishing a new TLS connection for every conversation
+func dialTLS() (io.ReadWriteCloser, error) {
+	conn, err := tlsUtils.GetClientTLSConnection()
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// newDialer returns dialTLS or, when useMux is set, a dialer opening streams on a single
+// multiplexed connection. The returned session is nil unless useMux is set.
+func newDialer(useMux bool) (dialer, *mux.Session, error) {
+	if !useMux {
+		return dialTLS, nil, nil
+	}
+
+	upgrade := &protocol.Frame{Type: protocol.MuxFrame}
+	announceHeartbeat(upgrade)
+	conn, reader, err := openTunnel(dialTLS, upgrade)
+	if err != nil {
+		return nil, nil, err
+	}
+	session := mux.Client(netUtils.WithReader(conn, reader))
+
+	// the monitor stops by itself once a ping fails on the closed session
+	monitor := startHeartbeat(session.Ping, func() {
+		session.Close()
+	})
+	session.SetPongHandler(monitor.Pong)
+
+	dial := func() (io.ReadWriteCloser, error) {
+		stream, err := session.OpenStream()
+		if err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+	return dial, session, nil
+}
+
+// openTunnel starts a conversation with the server, sends request and waits for it to
+// be confirmed with an OpenedFrame. The returned reader must be used for any further
+// reads as it may already hold data sent right after the confirmation.
+func openTunnel(dial dialer, request *protocol.Frame) (io.ReadWriteCloser, *bufio.Reader, error) {
+	conn, reader, _, err := requestTunnel(dial, request)
+	return conn, reader, err
+}
+
+// requestTunnel is openTunnel also returning the OpenedFrame, whose headers may carry
+// details about the conversation
+func requestTunnel(dial dialer, request *protocol.Frame) (io.ReadWriteCloser, *bufio.Reader, *protocol.Frame, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := protocol.WriteFrame(conn, request); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	reader := protocol.NewReader(conn)
+	reply, err := protocol.ReadFrame(reader)
+	if err == nil && reply.Type == protocol.ErrorFrame {
+		err = remoteError(reply.Body)
+	} else if err == nil && reply.Type != protocol.OpenedFrame {
+		err = fmt.Errorf("unexpected %s frame", reply.Type)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	return conn, reader, reply, nil
+}
+
+// remoteError is an error reported by the server, as opposed to one reaching it
+type remoteError string
+
+func (e remoteError) Error() string {
+	return string(e)
+}
@@ -16,10 +16,65 @@ func init() {
 	}
 
 	Commands = map[string]cli.CommandFactory{
+		"agent": func() (cli.Command, error) {
+			return &command.AgentCommand{
+				UI: ui,
+			}, nil
+		},
 		"send": func() (cli.Command, error) {
 			return &command.SendCommand{
 				UI: ui,
 			}, nil
 		},
+		"call": func() (cli.Command, error) {
+			return &command.CallCommand{
+				UI: ui,
+			}, nil
+		},
+		"exec": func() (cli.Command, error) {
+			return &command.ExecCommand{
+				UI: ui,
+			}, nil
+		},
+		"flush": func() (cli.Command, error) {
+			return &command.FlushCommand{
+				UI: ui,
+			}, nil
+		},
+		"expose": func() (cli.Command, error) {
+			return &command.ExposeCommand{
+				UI: ui,
+			}, nil
+		},
+		"forward": func() (cli.Command, error) {
+			return &command.ForwardCommand{
+				UI: ui,
+			}, nil
+		},
+		"pull": func() (cli.Command, error) {
+			return &command.PullCommand{
+				UI: ui,
+			}, nil
+		},
+		"push": func() (cli.Command, error) {
+			return &command.PushCommand{
+				UI: ui,
+			}, nil
+		},
+		"receive": func() (cli.Command, error) {
+			return &command.ReceiveCommand{
+				UI: ui,
+			}, nil
+		},
+		"subscribe": func() (cli.Command, error) {
+			return &command.SubscribeCommand{
+				UI: ui,
+			}, nil
+		},
+		"session": func() (cli.Command, error) {
+			return &command.SessionCommand{
+				UI: ui,
+			}, nil
+		},
 	}
 }
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/logging"
 	"github.com/mitchellh/cli"
 	"log"
 	"os"
 )
 
 func main() {
+	if err := logging.Setup(os.Stderr, cliUtils.GetLogFormat(), cliUtils.GetLogLevel()); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	c := cli.NewCLI("client", Version)
 	c.Args = os.Args[1:]
 	c.Commands = Commands
@@ -0,0 +1,554 @@
+/**
+ * outbox
+ * This package delivers messages to the server reliably across connection failures.
+ * Every message is given an id which is unique to this outbox, kept in memory until the
+ * server acknowledges or rejects it and sent again after a reconnect if it wasn't. The server uses
+ * the ids to drop messages it has already processed, so a message resent after a lost
+ * acknowledgement is not handled twice.
+ *
+ * Reconnects back off exponentially with full jitter so a fleet of clients doesn't
+ * stampede a server that just restarted.
+ */
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/mattsurabian/go-tls/shared/heartbeat"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"io"
+	mathrand "math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed  = errors.New("outbox: closed")
+	ErrFull    = errors.New("outbox: too many unacknowledged messages")
+	ErrTimeout = errors.New("outbox: timed out waiting for acknowledgements")
+)
+
+const (
+	defaultMinBackoff = 250 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	defaultMaxPending = 10000
+)
+
+// Config describes how an Outbox reaches the server and reports what happens
+type Config struct {
+	// Dial opens a new connection to the server
+	Dial func() (io.ReadWriteCloser, error)
+
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxAttempts is the number of consecutive failed dials after which the outbox
+	// gives up, zero retries forever
+	MaxAttempts int
+
+	// MaxPending bounds the number of unacknowledged messages held in memory
+	MaxPending int
+
+	// HeartbeatInterval and HeartbeatMisses configure dead-peer detection, a zero
+	// interval disables it
+	HeartbeatInterval time.Duration
+	HeartbeatMisses   int
+
+	// Optional callbacks, called from the outbox's goroutines
+	OnConnect    func(conn io.ReadWriteCloser)
+	OnDisconnect func(err error)
+	OnAck        func(id string)
+	OnReject     func(id string, reason string)
+	OnFrame      func(f *protocol.Frame)
+}
+
+// Stats describes the state of an Outbox
+type Stats struct {
+	Connected bool
+	Conn      io.ReadWriteCloser
+	Sent      int
+	Acked     int
+	Rejected  int
+	Resent    int
+	Pending   int
+	Heartbeat heartbeat.Stats
+}
+
+type entry struct {
+	seq     uint64
+	id      string
+	frame   *protocol.Frame
+	settled bool
+	writes  int
+}
+
+// Outbox sends messages and keeps them until the server acknowledges them
+type Outbox struct {
+	cfg    Config
+	prefix string
+
+	mu      sync.Mutex
+	seq     uint64
+	order   []*entry
+	pending map[string]*entry
+	conn    io.ReadWriteCloser
+	ready   bool
+	monitor *heartbeat.Monitor
+	err     error
+	stats   Stats
+	changed chan struct{}
+
+	writeMu sync.Mutex
+
+	redial    chan struct{}
+	closeOnce sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+}
+
+/**
+ * New
+ * Creates an Outbox and starts connecting to the server in the background.
+ */
+func New(cfg Config) *Outbox {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaultMaxPending
+	}
+
+	var b [6]byte
+	rand.Read(b[:])
+
+	o := &Outbox{
+		cfg:     cfg,
+		prefix:  hex.EncodeToString(b[:]),
+		pending: map[string]*entry{},
+		changed: make(chan struct{}),
+		redial:  make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+/**
+ * Send
+ * Assigns msg an id, queues it and writes it right away when connected. The id is
+ * returned so callers can match it against acknowledgements. A message which already
+ * carries an id, such as one read back from a spool, keeps it.
+ */
+func (o *Outbox) Send(msg *protocol.Frame) (string, error) {
+	o.mu.Lock()
+	if o.err != nil {
+		err := o.err
+		o.mu.Unlock()
+		return "", err
+	}
+	if len(o.pending) >= o.cfg.MaxPending {
+		o.mu.Unlock()
+		return "", ErrFull
+	}
+
+	o.seq++
+	id := msg.Header(protocol.HeaderID)
+	if id == "" {
+		id = o.prefix + "-" + strconv.FormatUint(o.seq, 10)
+		msg.SetHeader(protocol.HeaderID, id)
+	}
+	e := &entry{seq: o.seq, id: id, frame: msg}
+	o.order = append(o.order, e)
+	o.pending[id] = e
+	o.stats.Sent++
+
+	conn, ready := o.conn, o.ready
+	if ready {
+		e.writes++
+	}
+	o.mu.Unlock()
+
+	// when not connected the message goes out with the resend after the next connect
+	if ready {
+		o.write(conn, msg)
+	}
+	return id, nil
+}
+
+/**
+ * Flush
+ * Waits until every queued message has been acknowledged, the outbox gave up or the
+ * timeout expired. A timeout of zero waits forever.
+ */
+func (o *Outbox) Flush(timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		o.mu.Lock()
+		pending, err, changed := len(o.pending), o.err, o.changed
+		o.mu.Unlock()
+
+		if pending == 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+			return ErrTimeout
+		}
+	}
+}
+
+/**
+ * Unacknowledged
+ * Returns the messages that have not been acknowledged yet, in the order they were sent.
+ */
+func (o *Outbox) Unacknowledged() []*protocol.Frame {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var frames []*protocol.Frame
+	for _, e := range o.order {
+		if !e.settled {
+			frames = append(frames, e.frame)
+		}
+	}
+	return frames
+}
+
+/**
+ * Reconnect
+ * Drops the current connection and dials again straight away.
+ */
+func (o *Outbox) Reconnect() {
+	select {
+	case o.redial <- struct{}{}:
+	default:
+	}
+
+	o.mu.Lock()
+	conn := o.conn
+	o.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+/**
+ * Stats
+ * Returns a snapshot of the outbox state.
+ */
+func (o *Outbox) Stats() Stats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	stats := o.stats
+	stats.Connected = o.ready
+	stats.Conn = o.conn
+	stats.Pending = len(o.pending)
+	stats.Heartbeat = o.monitor.Stats()
+	return stats
+}
+
+/**
+ * Err
+ * Returns the error which made the outbox give up, or nil.
+ */
+func (o *Outbox) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+/**
+ * Close
+ * Closes the connection and stops reconnecting, unacknowledged messages are dropped.
+ */
+func (o *Outbox) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.closed)
+		o.mu.Lock()
+		conn := o.conn
+		o.mu.Unlock()
+		if conn != nil {
+			conn.Close()
+		}
+	})
+	<-o.done
+	return nil
+}
+
+// run keeps a connection to the server open until the outbox is closed or gives up
+func (o *Outbox) run() {
+	defer close(o.done)
+
+	attempt := 0
+	for {
+		select {
+		case <-o.closed:
+			o.fail(ErrClosed)
+			return
+		default:
+		}
+
+		conn, err := o.cfg.Dial()
+		if err != nil {
+			attempt++
+			if o.cfg.OnDisconnect != nil {
+				o.cfg.OnDisconnect(err)
+			}
+			if o.cfg.MaxAttempts > 0 && attempt >= o.cfg.MaxAttempts {
+				o.fail(err)
+				return
+			}
+
+			select {
+			case <-time.After(o.backoff(attempt)):
+			case <-o.redial:
+			case <-o.closed:
+				o.fail(ErrClosed)
+				return
+			}
+			continue
+		}
+		attempt = 0
+
+		err = o.serve(conn)
+		select {
+		case <-o.closed:
+			o.fail(ErrClosed)
+			return
+		default:
+		}
+		if o.cfg.OnDisconnect != nil {
+			o.cfg.OnDisconnect(err)
+		}
+
+		// pause briefly unless asked to redial, a server that accepts and then drops
+		// every connection would otherwise be hammered
+		select {
+		case <-o.redial:
+		case <-time.After(o.backoff(1)):
+		case <-o.closed:
+			o.fail(ErrClosed)
+			return
+		}
+	}
+}
+
+// serve announces heartbeats, resends what is pending and reads until the connection fails
+func (o *Outbox) serve(conn io.ReadWriteCloser) error {
+	defer conn.Close()
+
+	hello := protocol.NewPing()
+	if o.cfg.HeartbeatInterval > 0 {
+		hello.SetHeader(protocol.HeaderHeartbeat, o.cfg.HeartbeatInterval.String())
+	}
+	if err := o.write(conn, hello); err != nil {
+		return err
+	}
+
+	monitor := heartbeat.Start(o.cfg.HeartbeatInterval, o.cfg.HeartbeatMisses, func() error {
+		return o.write(conn, protocol.NewPing())
+	}, func() {
+		conn.Close()
+	})
+	defer monitor.Stop()
+
+	// Close may have run before the connection was published
+	o.mu.Lock()
+	select {
+	case <-o.closed:
+		o.mu.Unlock()
+		return ErrClosed
+	default:
+	}
+	o.conn = conn
+	o.monitor = monitor
+	o.mu.Unlock()
+
+	if o.cfg.OnConnect != nil {
+		o.cfg.OnConnect(conn)
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- o.readLoop(conn, monitor)
+	}()
+
+	if err := o.resend(conn); err != nil {
+		conn.Close()
+	}
+
+	err := <-readErr
+
+	o.mu.Lock()
+	o.ready = false
+	o.conn = nil
+	o.notify()
+	o.mu.Unlock()
+	return err
+}
+
+// resend writes every unacknowledged message in order, then marks the connection ready
+// for new messages. Messages queued while resending are picked up by the next pass.
+func (o *Outbox) resend(conn io.ReadWriteCloser) error {
+	var sentUpTo uint64
+	for {
+		o.mu.Lock()
+		var batch []*entry
+		for _, e := range o.order {
+			if !e.settled && e.seq > sentUpTo {
+				batch = append(batch, e)
+			}
+		}
+		if len(batch) == 0 {
+			o.ready = true
+			o.notify()
+			o.mu.Unlock()
+			return nil
+		}
+		for _, e := range batch {
+			if e.writes > 0 {
+				o.stats.Resent++
+			}
+			e.writes++
+		}
+		o.mu.Unlock()
+
+		for _, e := range batch {
+			if err := o.write(conn, e.frame); err != nil {
+				return err
+			}
+			sentUpTo = e.seq
+		}
+	}
+}
+
+func (o *Outbox) readLoop(conn io.ReadWriteCloser, monitor *heartbeat.Monitor) error {
+	reader := protocol.NewReader(conn)
+	for {
+		frame, err := protocol.ReadFrame(reader)
+		if err != nil {
+			return err
+		}
+
+		switch frame.Type {
+		case protocol.PingFrame:
+			o.write(conn, protocol.NewPong(frame))
+		case protocol.PongFrame:
+			monitor.Pong(frame.RTT())
+		case protocol.AckFrame:
+			o.ack(frame.Header(protocol.HeaderID))
+		case protocol.ErrorFrame:
+			// an error about one of our messages rejects it, anything else is for the caller
+			if id := frame.Header(protocol.HeaderID); id != "" && o.reject(id, string(frame.Body)) {
+				continue
+			}
+			if o.cfg.OnFrame != nil {
+				o.cfg.OnFrame(frame)
+			}
+		default:
+			if o.cfg.OnFrame != nil {
+				o.cfg.OnFrame(frame)
+			}
+		}
+	}
+}
+
+func (o *Outbox) ack(id string) {
+	o.mu.Lock()
+	ok := o.settle(id)
+	if ok {
+		o.stats.Acked++
+	}
+	o.mu.Unlock()
+
+	if ok && o.cfg.OnAck != nil {
+		o.cfg.OnAck(id)
+	}
+}
+
+// reject gives up on a message the server refused, reporting whether it was pending
+func (o *Outbox) reject(id string, reason string) bool {
+	o.mu.Lock()
+	ok := o.settle(id)
+	if ok {
+		o.stats.Rejected++
+	}
+	o.mu.Unlock()
+
+	if ok && o.cfg.OnReject != nil {
+		o.cfg.OnReject(id, reason)
+	}
+	return ok
+}
+
+// settle stops tracking a message the server has answered, callers must hold o.mu
+func (o *Outbox) settle(id string) bool {
+	e, ok := o.pending[id]
+	if !ok {
+		return false
+	}
+	e.settled = true
+	delete(o.pending, id)
+
+	// drop the answered prefix so the order slice doesn't grow forever
+	i := 0
+	for i < len(o.order) && o.order[i].settled {
+		i++
+	}
+	o.order = o.order[i:]
+	o.notify()
+	return true
+}
+
+func (o *Outbox) write(conn io.ReadWriteCloser, f *protocol.Frame) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+	return protocol.WriteFrame(conn, f)
+}
+
+func (o *Outbox) fail(err error) {
+	o.mu.Lock()
+	if o.err == nil {
+		o.err = err
+	}
+	o.notify()
+	o.mu.Unlock()
+}
+
+// notify wakes everyone waiting in Flush, callers must hold o.mu
+func (o *Outbox) notify() {
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// backoff returns a random delay up to MinBackoff doubled for every failed attempt,
+// capped at MaxBackoff
+func (o *Outbox) backoff(attempt int) time.Duration {
+	ceiling := o.cfg.MinBackoff
+	for i := 1; i < attempt && ceiling < o.cfg.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > o.cfg.MaxBackoff {
+		ceiling = o.cfg.MaxBackoff
+	}
+	return time.Duration(mathrand.Int63n(int64(ceiling)) + 1)
+}
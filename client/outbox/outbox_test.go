@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"errors"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer hands out in-memory connections and records the messages it receives.
+// The first dropFirst connections are closed after reading one message, without acking,
+// and messages whose body is reject are answered with an error.
+type fakeServer struct {
+	mu        sync.Mutex
+	dials     int
+	dropFirst int
+	reject    string
+	received  []string
+}
+
+func (f *fakeServer) dial() (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+
+	f.mu.Lock()
+	f.dials++
+	drop := f.dials <= f.dropFirst
+	f.mu.Unlock()
+
+	go func() {
+		defer server.Close()
+		for {
+			frame, err := protocol.ReadFrame(server)
+			if err != nil {
+				return
+			}
+			if frame.Type != protocol.MessageFrame {
+				continue
+			}
+
+			f.mu.Lock()
+			f.received = append(f.received, string(frame.Body))
+			f.mu.Unlock()
+
+			if drop {
+				return
+			}
+			reply := protocol.NewAck(frame.Header(protocol.HeaderID))
+			if f.reject != "" && string(frame.Body) == f.reject {
+				reply = protocol.NewError(errors.New("not allowed"))
+				reply.SetHeader(protocol.HeaderID, frame.Header(protocol.HeaderID))
+			}
+			protocol.WriteFrame(server, reply)
+		}
+	}()
+
+	return client, nil
+}
+
+func TestResendAfterDroppedConnection(t *testing.T) {
+	server := &fakeServer{dropFirst: 1}
+	o := New(Config{
+		Dial:       server.dial,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	})
+	defer o.Close()
+
+	ids := map[string]bool{}
+	for _, body := range []string{"one", "two", "three"} {
+		id, err := o.Send(protocol.NewMessage([]byte(body)))
+		if err != nil {
+			t.Fatalf("Error sending: %v", err)
+		}
+		ids[id] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("Expected unique message ids, Got: %v", ids)
+	}
+
+	if err := o.Flush(time.Second); err != nil {
+		t.Fatalf("Expected every message to be acknowledged, Got: %v", err)
+	}
+
+	stats := o.Stats()
+	if stats.Acked != 3 || stats.Pending != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.dials < 2 {
+		t.Errorf("Expected a reconnect, Got: %d dials", server.dials)
+	}
+	last := server.received[len(server.received)-3:]
+	if last[0] != "one" || last[1] != "two" || last[2] != "three" {
+		t.Errorf("Expected messages to be resent in order, Got: %v", server.received)
+	}
+}
+
+func TestRejectedMessagesAreNotResent(t *testing.T) {
+	server := &fakeServer{reject: "denied"}
+
+	var mu sync.Mutex
+	rejected := map[string]string{}
+	o := New(Config{
+		Dial: server.dial,
+		OnReject: func(id string, reason string) {
+			mu.Lock()
+			rejected[id] = reason
+			mu.Unlock()
+		},
+	})
+	defer o.Close()
+
+	o.Send(protocol.NewMessage([]byte("fine")))
+	id, _ := o.Send(protocol.NewMessage([]byte("denied")))
+	if err := o.Flush(time.Second); err != nil {
+		t.Fatalf("Expected every message to be answered, Got: %v", err)
+	}
+
+	if stats := o.Stats(); stats.Acked != 1 || stats.Rejected != 1 || stats.Pending != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if rejected[id] != "not allowed" {
+		t.Errorf("Expected %s to be rejected, Got: %v", id, rejected)
+	}
+	if frames := o.Unacknowledged(); len(frames) != 0 {
+		t.Errorf("Expected nothing left to resend, Got: %v", frames)
+	}
+}
+
+func TestGiveUpAfterMaxAttempts(t *testing.T) {
+	refused := errors.New("connection refused")
+	o := New(Config{
+		Dial:        func() (io.ReadWriteCloser, error) { return nil, refused },
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		MaxAttempts: 3,
+	})
+	defer o.Close()
+
+	o.Send(protocol.NewMessage([]byte("queued")))
+	if err := o.Flush(time.Second); err != refused {
+		t.Errorf("Expected the dial error, Got: %v", err)
+	}
+	if frames := o.Unacknowledged(); len(frames) != 1 || string(frames[0].Body) != "queued" {
+		t.Errorf("Expected the message to remain unacknowledged, Got: %v", frames)
+	}
+	if _, err := o.Send(protocol.NewMessage(nil)); err != refused {
+		t.Errorf("Expected sends to fail once the outbox gave up, Got: %v", err)
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	o := &Outbox{cfg: Config{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	for attempt := 1; attempt < 20; attempt++ {
+		for i := 0; i < 50; i++ {
+			d := o.backoff(attempt)
+			if d <= 0 || d > time.Second {
+				t.Fatalf("Backoff out of bounds! Attempt: %d, Got: %s", attempt, d)
+			}
+			if attempt == 1 && d > 100*time.Millisecond {
+				t.Fatalf("Expected the first backoff to stay under the minimum, Got: %s", d)
+			}
+		}
+	}
+}
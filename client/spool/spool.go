@@ -0,0 +1,223 @@
+/**
+ * spool
+ * This package keeps messages which couldn't be delivered in a local directory until the
+ * server is reachable again. Every message is stored as a frame in a file of its own, named
+ * so that sorting the names gives the order messages were spooled in.
+ *
+ * Files are written under a temporary name, synced and then renamed into place, so a crash
+ * leaves either a complete message or nothing. Leftover temporary files are cleaned up the
+ * next time the spool is opened.
+ */
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrFull = errors.New("spool: size limit reached")
+
+const (
+	messageSuffix  = ".msg"
+	tempPrefix     = ".tmp-"
+	rejectedSuffix = ".bad"
+
+	// temporary files this old can't belong to a write in progress
+	staleTempAge = time.Hour
+)
+
+// Entry is a message waiting in the spool
+type Entry struct {
+	Name string
+	Size int64
+}
+
+// Spool is a bounded FIFO of messages kept on disk
+type Spool struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64
+	last int64
+}
+
+/**
+ * Open
+ * Opens the spool in dir, creating the directory if necessary. maxSize bounds the total
+ * size of the spooled messages, it is enforced for writes made through this Spool.
+ */
+func Open(dir string, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxSize: maxSize}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), tempPrefix) && time.Since(f.ModTime()) > staleTempAge {
+			os.Remove(filepath.Join(dir, f.Name()))
+		} else if strings.HasSuffix(f.Name(), messageSuffix) {
+			s.size += f.Size()
+		}
+	}
+	return s, nil
+}
+
+// Dir returns the directory holding the spool
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+/**
+ * Enqueue
+ * Appends a message to the spool, it is on disk by the time Enqueue returns.
+ */
+func (s *Spool) Enqueue(f *protocol.Frame) error {
+	tmp, err := ioutil.TempFile(s.dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := protocol.WriteFrame(tmp, f); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+info.Size() > s.maxSize {
+		return ErrFull
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, s.nextName())); err != nil {
+		return err
+	}
+	s.size += info.Size()
+	return syncDir(s.dir)
+}
+
+/**
+ * Entries
+ * Lists the spooled messages, oldest first.
+ */
+func (s *Spool) Entries() ([]Entry, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), messageSuffix) {
+			entries = append(entries, Entry{Name: f.Name(), Size: f.Size()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Len returns the number of spooled messages
+func (s *Spool) Len() (int, error) {
+	entries, err := s.Entries()
+	return len(entries), err
+}
+
+/**
+ * Read
+ * Returns the message stored for an entry.
+ */
+func (s *Spool) Read(e Entry) (*protocol.Frame, error) {
+	f, err := os.Open(filepath.Join(s.dir, e.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return protocol.ReadFrame(f)
+}
+
+/**
+ * Remove
+ * Deletes a delivered message from the spool. Removing an entry which is already gone,
+ * because another process delivered it, is not an error.
+ */
+func (s *Spool) Remove(e Entry) error {
+	err := os.Remove(filepath.Join(s.dir, e.Name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		s.mu.Lock()
+		s.size -= e.Size
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+/**
+ * Reject
+ * Moves an unreadable message out of the way so it doesn't block the ones after it, the
+ * file is kept next to the spool for inspection.
+ */
+func (s *Spool) Reject(e Entry) error {
+	slog.Warn("spool: rejecting unreadable message", "name", e.Name)
+	path := filepath.Join(s.dir, e.Name)
+	if err := os.Rename(path, path+rejectedSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.mu.Lock()
+	s.size -= e.Size
+	s.mu.Unlock()
+	return nil
+}
+
+// nextName returns a name sorting after every name handed out so far, the process id
+// keeps names unique when several clients share a spool. Callers must hold s.mu.
+func (s *Spool) nextName() string {
+	now := time.Now().UnixNano()
+	if now <= s.last {
+		now = s.last + 1
+	}
+	s.last = now
+	return fmt.Sprintf("%020d-%08d%s", now, os.Getpid(), messageSuffix)
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// not every platform supports syncing a directory, the rename is still atomic
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,84 @@
+package spool
+
+import (
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFIFOAcrossReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Error opening spool: %v", err)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		msg := protocol.NewMessage([]byte(body))
+		msg.SetHeader(protocol.HeaderID, "id-"+body)
+		if err := s.Enqueue(msg); err != nil {
+			t.Fatalf("Error spooling: %v", err)
+		}
+	}
+
+	// a write interrupted by a crash must not show up as a message
+	ioutil.WriteFile(filepath.Join(dir, tempPrefix+"crashed"), []byte{1, 2}, 0600)
+
+	s, err = Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Error reopening spool: %v", err)
+	}
+	entries, err := s.Entries()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, Got: %v, %v", entries, err)
+	}
+
+	for i, body := range []string{"one", "two", "three"} {
+		msg, err := s.Read(entries[i])
+		if err != nil {
+			t.Fatalf("Error reading entry: %v", err)
+		}
+		if string(msg.Body) != body || msg.Header(protocol.HeaderID) != "id-"+body {
+			t.Errorf("Entry %d out of order or corrupted, Got: %s %q", i, msg.Header(protocol.HeaderID), msg.Body)
+		}
+	}
+
+	s.Remove(entries[0])
+	if n, _ := s.Len(); n != 2 {
+		t.Errorf("Expected 2 entries after a remove, Got: %d", n)
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, _ := Open(dir, 80)
+	body := make([]byte, 40)
+	if err := s.Enqueue(protocol.NewMessage(body)); err != nil {
+		t.Fatalf("Error spooling: %v", err)
+	}
+	if err := s.Enqueue(protocol.NewMessage(body)); err != ErrFull {
+		t.Errorf("Expected ErrFull, Got: %v", err)
+	}
+
+	entries, _ := s.Entries()
+	s.Remove(entries[0])
+	if err := s.Enqueue(protocol.NewMessage(body)); err != nil {
+		t.Errorf("Expected room after a remove, Got: %v", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected rejected writes to leave no files behind, Got: %d files", len(files))
+	}
+}
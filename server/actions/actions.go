@@ -0,0 +1,203 @@
+/**
+ * actions
+ * This package runs preconfigured commands on the server on behalf of clients. Actions
+ * are read from a file of sections, one per action:
+ *
+ *  # deploys the site, only the CI client may trigger it
+ *  [deploy]
+ *  command    = /usr/local/bin/deploy --env production
+ *  allow      = CN=ci CN=admin
+ *  timeout    = 5m
+ *  max-output = 1MB
+ *
+ * The command is split on whitespace and run directly, never through a shell, so a
+ * client can only choose which action runs and what it reads on stdin. Only the listed
+ * identities, or every client when * is listed, may run an action. Timeout and max-output,
+ * which limits stdout and stderr each, are optional.
+ */
+package actions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/rpc"
+	"github.com/mattsurabian/go-tls/server/sections"
+	"github.com/mattsurabian/go-tls/shared/acl"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/units"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// MethodPrefix is prepended to an action's name to form the rpc method running it
+const MethodPrefix = "exec."
+
+const (
+	defaultTimeout   = time.Minute
+	defaultMaxOutput = 1 << 20
+
+	// how long to wait for the output of a killed command to be closed, a command which
+	// started children of its own may otherwise keep its pipes open
+	killGrace = 2 * time.Second
+)
+
+// Action is a command clients may run by name
+type Action struct {
+	Name      string
+	Command   []string
+	Timeout   time.Duration
+	MaxOutput int
+
+	allow *acl.List
+}
+
+// Result describes a finished action, it is sent to the client as JSON
+type Result struct {
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+	TimedOut  bool   `json:"timed_out,omitempty"`
+}
+
+/**
+ * Load
+ * Reads the actions file at filePath. An empty path yields no actions.
+ */
+func Load(filePath string) ([]*Action, error) {
+	if filePath == "" {
+		return nil, nil
+	}
+
+	secs, err := sections.Read(filePath, "action")
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []*Action
+	for _, section := range secs {
+		current := &Action{Name: section.Name, Timeout: defaultTimeout, MaxOutput: defaultMaxOutput, allow: acl.New()}
+		actions = append(actions, current)
+		for _, entry := range section.Entries {
+			key, value, lineNumber := entry.Key, entry.Value, entry.Line
+			switch key {
+			case "command":
+				current.Command = strings.Fields(value)
+			case "allow":
+				for _, identity := range strings.Fields(value) {
+					current.allow.Add(identity, current.Name)
+				}
+			case "timeout":
+				current.Timeout, err = time.ParseDuration(value)
+				if err != nil || current.Timeout <= 0 {
+					return nil, fmt.Errorf("%s:%d: invalid timeout %q", filePath, lineNumber, value)
+				}
+			case "max-output":
+				maxOutput, err := units.ParseSize(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid max-output %q", filePath, lineNumber, value)
+				}
+				current.MaxOutput = int(maxOutput)
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key %q", filePath, lineNumber, key)
+			}
+		}
+	}
+
+	for _, action := range actions {
+		if len(action.Command) == 0 {
+			return nil, fmt.Errorf("%s: action %s has no command", filePath, action.Name)
+		}
+		if action.allow.Empty() {
+			return nil, fmt.Errorf("%s: action %s allows no clients", filePath, action.Name)
+		}
+	}
+	return actions, nil
+}
+
+/**
+ * Handle
+ * Runs the action with the request payload on stdin and returns its Result as JSON.
+ * A command which ran but failed is not an error, its exit code is in the Result.
+ */
+func (a *Action) Handle(ctx context.Context, peer rpc.Peer, req rpc.Request) (rpc.Response, error) {
+	if !a.Allowed(peer.Identity) {
+		return rpc.Response{}, fmt.Errorf("%s may not run %s", peer.Identity, a.Name)
+	}
+
+	result, err := a.Run(ctx, req.Payload)
+	if err != nil {
+		return rpc.Response{}, err
+	}
+	slog.Info("exec", "action", a.Name, logging.ClientCN, peer.Identity, "exit_code", result.ExitCode, "timed_out", result.TimedOut)
+
+	payload, err := json.Marshal(result)
+	return rpc.Response{Payload: payload}, err
+}
+
+// Allowed reports whether the client with identity may run the action
+func (a *Action) Allowed(identity string) bool {
+	return a.allow.Allowed(identity, a.Name)
+}
+
+/**
+ * Run
+ * Runs the command with stdin as its input, enforcing the timeout and output limit.
+ */
+func (a *Action) Run(ctx context.Context, stdin []byte) (*Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.Timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: a.MaxOutput}
+	stderr := &limitedBuffer{limit: a.MaxOutput}
+
+	cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = killGrace
+
+	err := cmd.Run()
+	result := &Result{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+		TimedOut:  ctx.Err() == context.DeadlineExceeded,
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil && !result.TimedOut {
+		// the command couldn't be started at all, that is a configuration problem
+		return nil, fmt.Errorf("unable to run %s: %v", a.Name, err)
+	}
+	return result, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and silently drops the rest,
+// so a chatty command is never blocked on a full pipe. The buffer isn't embedded as its
+// ReadFrom would let io.Copy bypass the limit.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
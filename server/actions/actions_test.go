@@ -0,0 +1,117 @@
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mattsurabian/go-tls/server/rpc"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperArg makes the test binary run one of the helpers instead of the tests, so the
+// actions don't depend on the commands installed on the machine
+const helperArg = "-actions-test-helper"
+
+func TestMain(m *testing.M) {
+	if len(os.Args) == 3 && os.Args[1] == helperArg {
+		runHelper(os.Args[2])
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func runHelper(name string) {
+	switch name {
+	case "cat":
+		io.Copy(os.Stdout, os.Stdin)
+	case "fail":
+		os.Exit(1)
+	case "sleep":
+		time.Sleep(5 * time.Second)
+	case "yes":
+		for {
+			if _, err := os.Stdout.WriteString("y\n"); err != nil {
+				os.Exit(1)
+			}
+		}
+	default:
+		os.Exit(2)
+	}
+}
+
+func loadTestActions(t *testing.T) map[string]*Action {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := os.ReadFile("testdata/actions.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	confPath := filepath.Join(t.TempDir(), "actions.conf")
+	conf = []byte(strings.ReplaceAll(string(conf), "@test@", self+" "+helperArg))
+	if err := os.WriteFile(confPath, conf, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := Load(confPath)
+	if err != nil {
+		t.Fatalf("Error loading actions: %v", err)
+	}
+	byName := map[string]*Action{}
+	for _, action := range list {
+		byName[action.Name] = action
+	}
+	return byName
+}
+
+func call(t *testing.T, a *Action, identity string, payload string) (*Result, error) {
+	resp, err := a.Handle(context.Background(), rpc.Peer{Identity: identity}, rpc.Request{Payload: []byte(payload)})
+	if err != nil {
+		return nil, err
+	}
+	var result Result
+	if err := json.Unmarshal(resp.Payload, &result); err != nil {
+		t.Fatalf("Invalid result %q: %v", resp.Payload, err)
+	}
+	return &result, nil
+}
+
+func TestPayloadOnStdin(t *testing.T) {
+	actions := loadTestActions(t)
+	result, err := call(t, actions["cat"], "CN=Client0", "hello")
+	if err != nil || result.Stdout != "hello" || result.ExitCode != 0 {
+		t.Errorf("Expected the payload echoed back, Got: %+v, %v", result, err)
+	}
+}
+
+func TestIdentityRestriction(t *testing.T) {
+	actions := loadTestActions(t)
+	if _, err := call(t, actions["cat"], "CN=Intruder", "hello"); err == nil {
+		t.Error("Expected an unlisted identity to be refused")
+	}
+
+	// fail allows every client and reports the exit code rather than an error
+	result, err := call(t, actions["fail"], "CN=Intruder", "")
+	if err != nil || result.ExitCode != 1 {
+		t.Errorf("Expected exit code 1, Got: %+v, %v", result, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	actions := loadTestActions(t)
+
+	result, err := call(t, actions["slow"], "CN=Client0", "")
+	if err != nil || !result.TimedOut {
+		t.Errorf("Expected the action to time out, Got: %+v, %v", result, err)
+	}
+
+	result, err = call(t, actions["chatty"], "CN=Client0", "")
+	if err != nil || !result.Truncated || len(result.Stdout) != 1024 {
+		t.Errorf("Expected output truncated to 1K, Got: %d bytes, %v", len(result.Stdout), err)
+	}
+}
@@ -0,0 +1,336 @@
+/**
+ * audit
+ * This package keeps a tamper-evident audit trail as a file of JSON lines, one entry per
+ * line. Every entry holds the SHA-256 of the entry before it, and its own hash covers that
+ * link, so changing, removing or reordering any entry breaks the chain from there on:
+ *
+ *  {"seq":1,"time":"...","event":"start","prev":"000...000","hash":"9f2c..."}
+ *  {"seq":2,"time":"...","event":"handshake","fields":{"result":"accepted",...},"prev":"9f2c...","hash":"41d0..."}
+ *
+ * Dropping entries from the end leaves a valid chain, so the sequence number and hash of
+ * the latest entry are also kept in a head file next to the log, <log>.head. Copying the
+ * head somewhere the server can't write to, e.g. a log collector, lets a reviewer prove
+ * nothing was removed since.
+ */
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTampered is wrapped by every error Verify returns for a broken chain
+var ErrTampered = errors.New("audit log has been tampered with")
+
+// genesis is the previous hash of the first entry
+var genesis = strings.Repeat("0", sha256.Size*2)
+
+// headSuffix names the file holding the sequence number and hash of the latest entry
+const headSuffix = ".head"
+
+// Entry is one event in the audit log
+type Entry struct {
+	Seq    uint64            `json:"seq"`
+	Time   time.Time         `json:"time"`
+	Event  string            `json:"event"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Prev   string            `json:"prev"`
+	Hash   string            `json:"hash,omitempty"`
+}
+
+// Log appends entries to an audit log file, it is safe to use from several goroutines.
+// A nil Log records nothing, so callers needn't check whether auditing is enabled.
+type Log struct {
+	mu   sync.Mutex
+	file *os.File
+	head string
+	seq  uint64
+	prev string
+
+	// the error of the last Record, it clears once an entry is written again
+	err error
+
+	// syncMu lets one Record sync the log and write the head for every entry written so
+	// far, the Records waiting for it meanwhile find their entries on disk already
+	syncMu sync.Mutex
+	synced uint64
+	last   *Entry
+}
+
+// Summary describes an audit log that passed verification
+type Summary struct {
+	Entries int
+	Last    *Entry
+
+	// HeadChecked is false when there was no head file to compare the end of the log with
+	HeadChecked bool
+}
+
+/**
+ * Open
+ * Opens the audit log at path for appending, creating it if necessary, and continues the
+ * chain from its last entry.
+ */
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{file: file, head: path + headSuffix, prev: genesis}
+	last, err := lastEntry(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v, run audit verify", path, err)
+	}
+	if last != nil {
+		l.seq, l.prev = last.Seq, last.Hash
+		l.synced = last.Seq
+	}
+	return l, nil
+}
+
+/**
+ * Record
+ * Appends an event with the given fields and waits for it to reach the disk. Events
+ * recorded at the same time share one sync of the log and one write of the head.
+ */
+func (l *Log) Record(event string, fields map[string]string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	err := l.append(event, fields)
+	seq := l.seq
+	l.mu.Unlock()
+
+	if err == nil {
+		err = l.sync(seq)
+	}
+	l.mu.Lock()
+	l.err = err
+	l.mu.Unlock()
+	return err
+}
+
+// sync makes sure the entries up to seq are on disk, along with a head at least as recent
+func (l *Log) sync(seq uint64) error {
+	l.syncMu.Lock()
+	defer l.syncMu.Unlock()
+	if l.synced >= seq {
+		return nil
+	}
+
+	l.mu.Lock()
+	last := l.last
+	l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	if err := writeHead(l.head, last); err != nil {
+		return err
+	}
+	l.synced = last.Seq
+	return nil
+}
+
+/**
+ * Err
+ * Returns why the last entry couldn't be recorded, or nil if it was.
+ */
+func (l *Log) Err() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// append writes one entry without waiting for the disk, the caller holds the lock
+func (l *Log) append(event string, fields map[string]string) error {
+	e := &Entry{
+		Seq:    l.seq + 1,
+		Time:   time.Now().UTC(),
+		Event:  event,
+		Fields: fields,
+		Prev:   l.prev,
+	}
+	hash, err := e.digest()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.seq, l.prev, l.last = e.Seq, e.Hash, e
+	return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+/**
+ * Verify
+ * Checks every link of the chain in the audit log at path and compares its end with the
+ * head file. Errors for a broken chain wrap ErrTampered and name the first bad line.
+ */
+func Verify(path string) (*Summary, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	summary := &Summary{}
+	prev := genesis
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: line %d is incomplete", ErrTampered, line)
+		}
+
+		e, err := parseEntry(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrTampered, line, err)
+		}
+		if e.Seq != uint64(line) {
+			return nil, fmt.Errorf("%w: line %d holds entry %d, entries are missing or out of order", ErrTampered, line, e.Seq)
+		}
+		if e.Prev != prev {
+			return nil, fmt.Errorf("%w: line %d doesn't follow the entry before it", ErrTampered, line)
+		}
+		if hash, err := e.digest(); err != nil || hash != e.Hash {
+			return nil, fmt.Errorf("%w: line %d has been modified", ErrTampered, line)
+		}
+
+		prev = e.Hash
+		summary.Entries++
+		summary.Last = e
+	}
+
+	seq, hash, err := readHead(path + headSuffix)
+	if os.IsNotExist(err) {
+		return summary, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary.HeadChecked = true
+
+	last := uint64(summary.Entries)
+	switch {
+	case seq > last:
+		return nil, fmt.Errorf("%w: the log ends at entry %d but the head records entry %d, it has been truncated",
+			ErrTampered, last, seq)
+	case seq == last && seq > 0 && hash != summary.Last.Hash:
+		return nil, fmt.Errorf("%w: the last entry doesn't match the head", ErrTampered)
+	}
+	// a head behind the log means the server stopped between writing an entry and the
+	// head, the entries after it are still chained
+	return summary, nil
+}
+
+// digest hashes the entry without its own hash
+func (e *Entry) digest() (string, error) {
+	unhashed := *e
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func parseEntry(data []byte) (*Entry, error) {
+	e := &Entry{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// lastEntry returns the final entry of an audit log, or nil if it is empty
+func lastEntry(file *os.File) (*Entry, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var last []byte
+	reader := bufio.NewReader(file)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				return nil, errors.New("the last entry is incomplete")
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = data
+	}
+	if last == nil {
+		return nil, nil
+	}
+	return parseEntry(last)
+}
+
+// writeHead replaces the head file, through a rename so it is never half written
+func writeHead(path string, e *Entry) error {
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %s\n", e.Seq, e.Hash)
+	if err := os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readHead(path string) (uint64, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+	parts := strings.Fields(string(data))
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("%s: invalid head file", path)
+	}
+	seq, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: invalid head file", path)
+	}
+	return seq, parts[1], nil
+}
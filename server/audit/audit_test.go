@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeLog records n events, reopening the log halfway to check the chain carries on
+func writeLog(t *testing.T, n int) string {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for _, count := range []int{n / 2, n - n/2} {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatalf("Error opening the log: %v", err)
+		}
+		for i := 0; i < count; i++ {
+			if err := l.Record("handshake", map[string]string{"client_cn": "CN=Client0", "result": "accepted"}); err != nil {
+				t.Fatalf("Error recording: %v", err)
+			}
+		}
+		l.Close()
+	}
+	return path
+}
+
+func readLines(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	data := strings.Join(lines, "")
+	if !strings.HasSuffix(data, "\n") {
+		data += "\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyIntactLog(t *testing.T) {
+	path := writeLog(t, 6)
+
+	summary, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Expected an intact log, Got: %v", err)
+	}
+	if summary.Entries != 6 || summary.Last.Seq != 6 || !summary.HeadChecked {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+}
+
+func TestConcurrentRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Error opening the log: %v", err)
+	}
+	defer l.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := l.Record("authorize", map[string]string{"decision": "allowed"}); err != nil {
+					t.Errorf("Error recording: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	summary, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Expected an intact log, Got: %v", err)
+	}
+	if summary.Entries != 500 || !summary.HeadChecked {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if seq, hash, _ := readHead(path + headSuffix); seq != 500 || hash != summary.Last.Hash {
+		t.Errorf("Expected the head to record the last entry, Got: %d %s", seq, hash)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		reason string
+	}{
+		{"modified", func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], "accepted", "rejected", 1)
+			return lines
+		}, "line 3 has been modified"},
+		{"removed", func(lines []string) []string {
+			return append(lines[:2], lines[3:]...)
+		}, "line 3 holds entry 4"},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, "line 2 holds entry 3"},
+		{"truncated", func(lines []string) []string {
+			return lines[:4]
+		}, "has been truncated"},
+		{"partial line", func(lines []string) []string {
+			lines[5] = lines[5][:20]
+			return lines
+		}, "line 6"},
+	}
+
+	for _, test := range tests {
+		path := writeLog(t, 6)
+		writeLines(t, path, test.tamper(readLines(t, path)))
+
+		_, err := Verify(path)
+		if !errors.Is(err, ErrTampered) || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: Expected an error containing %q, Got: %v", test.name, test.reason, err)
+		}
+	}
+}
+
+func TestOpenRefusesIncompleteLog(t *testing.T) {
+	path := writeLog(t, 2)
+	lines := readLines(t, path)
+	os.WriteFile(path, []byte(lines[0]+lines[1][:10]), 0600)
+
+	if _, err := Open(path); err == nil {
+		t.Error("Expected a log ending in an incomplete entry to be refused")
+	}
+}
+
+func TestNilLogRecordsNothing(t *testing.T) {
+	var l *Log
+	if err := l.Record("start", nil); err != nil {
+		t.Errorf("Expected a nil log to ignore records, Got: %v", err)
+	}
+}
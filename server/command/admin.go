@@ -0,0 +1,259 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mitchellh/cli"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// AdminCommand controls a running server through its admin socket
+type AdminCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *AdminCommand) Help() string {
+	help := `
+Usage: [flags] admin [options] <subcommand> [argument]
+  Controls the server running on this machine through the Unix socket set with
+  the admin-socket option. Only the user running the server may connect to it,
+  and every admin action is written to the audit log.
+
+Subcommands:
+  connections        List open client connections with their identity and bytes
+                     received and sent.
+  kick <conn_id>     Close a client connection.
+  reload             Load the certificates, keys and CAs of every listener again,
+                     new connections use them and open ones are left alone.
+  log-level [level]  Set the log level, or switch between info and debug.
+  config             Show the configuration the server is running with.
+
+Options:
+  --json   Print the server's answer as JSON.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *AdminCommand) Synopsis() string {
+	return "Control the running server"
+}
+
+// Run the actual command
+func (c *AdminCommand) Run(args []string) int {
+	var asJSON bool
+
+	cmdFlags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.BoolVar(&asJSON, "json", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+
+	args = cmdFlags.Args()
+	if len(args) == 0 || len(args) > 2 {
+		c.UI.Error("Expected a subcommand and an optional argument, run -h for more info")
+		return BAD_REQUEST
+	}
+	subcommand, argument := args[0], ""
+	if len(args) == 2 {
+		argument = args[1]
+	}
+
+	result, err := adminCall(subcommand, argument)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if asJSON {
+		c.UI.Output(string(result))
+		return OK
+	}
+
+	switch subcommand {
+	case "connections":
+		var conns []connInfo
+		if err := json.Unmarshal(result, &conns); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+		c.UI.Output(formatConnections(conns))
+	case "config":
+		var values map[string]string
+		if err := json.Unmarshal(result, &values); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.UI.Output(fmt.Sprintf("%s = %s", name, values[name]))
+		}
+	default:
+		var message string
+		if err := json.Unmarshal(result, &message); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+		c.UI.Output(message)
+	}
+	return OK
+}
+
+// adminCall sends one request to the admin socket and returns the JSON answer
+func adminCall(subcommand string, argument string) ([]byte, error) {
+	path := cliUtils.GetAdminSocketPath()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach a server on %s, is it running? %v", path, err)
+	}
+	defer conn.Close()
+
+	call := &protocol.Frame{Type: protocol.CallFrame, Body: []byte(argument)}
+	call.SetHeader(protocol.HeaderMethod, subcommand)
+	if err := protocol.WriteFrame(conn, call); err != nil {
+		return nil, err
+	}
+
+	reply, err := protocol.ReadFrame(protocol.NewReader(conn))
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == protocol.ErrorFrame {
+		return nil, fmt.Errorf("%s", reply.Body)
+	}
+	return reply.Body, nil
+}
+
+func formatConnections(conns []connInfo) string {
+	if len(conns) == 0 {
+		return "No open connections"
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONN_ID\tLISTENER\tCLIENT_CN\tREMOTE_ADDR\tTLS\tOPEN FOR\tRECEIVED\tSENT")
+	for _, conn := range conns {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n", conn.ID, conn.Listener, conn.ClientCN, conn.RemoteAddr, conn.TLSVersion,
+			time.Since(conn.Opened).Round(time.Second), conn.BytesReceived, conn.BytesSent)
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// serveAdmin answers requests on the admin socket until it is closed
+func (s *server) serveAdmin(listener net.Listener) {
+	slog.Info("admin socket listening", "socket", listener.Addr().String())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleAdmin(conn)
+	}
+}
+
+// handleAdmin answers the calls made on one admin connection
+func (s *server) handleAdmin(conn net.Conn) {
+	defer conn.Close()
+
+	reader := protocol.NewReader(conn)
+	for {
+		call, err := protocol.ReadFrame(reader)
+		if err != nil {
+			return
+		}
+		if call.Type != protocol.CallFrame {
+			protocol.WriteFrame(conn, protocol.NewError(fmt.Errorf("unexpected %s frame", call.Type)))
+			return
+		}
+
+		method := call.Header(protocol.HeaderMethod)
+		argument := string(call.Body)
+		result, err := s.admin(method, argument)
+
+		fields := map[string]string{"action": method, "argument": argument, "result": "ok"}
+		if err != nil {
+			fields["result"] = err.Error()
+		}
+		s.record(slog.Default(), "admin", fields)
+
+		var reply *protocol.Frame
+		if err == nil {
+			var payload []byte
+			if payload, err = json.Marshal(result); err == nil {
+				reply = protocol.NewReply(call.Header(protocol.HeaderID), payload)
+			}
+		}
+		if err != nil {
+			reply = protocol.NewError(err)
+		}
+		if err := protocol.WriteFrame(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// admin carries out one admin request, the result is sent back as JSON
+func (s *server) admin(method string, argument string) (interface{}, error) {
+	switch method {
+	case "connections":
+		return s.conns.list(), nil
+
+	case "kick":
+		identity, ok := s.conns.kick(argument)
+		if !ok {
+			return nil, fmt.Errorf("no open connection %q", argument)
+		}
+		slog.Warn("connection kicked by an admin", logging.ConnID, argument, logging.ClientCN, identity)
+		return fmt.Sprintf("Closed connection %s from %s", argument, identity), nil
+
+	case "reload":
+		for _, e := range s.endpoints {
+			if err := e.creds.Reload(); err != nil {
+				slog.Error("certificate reload failed", logging.Listener, e.Name, "error", err)
+				return nil, fmt.Errorf("listener %s: %v", e.Name, err)
+			}
+			e.recordCertExpiry(s.metrics)
+		}
+		slog.Info("certificates reloaded")
+		return "Certificates reloaded, new connections will use them", nil
+
+	case "log-level":
+		level := argument
+		if level == "" {
+			level = "debug"
+			if logging.Level() == "debug" {
+				level = "info"
+			}
+		}
+		if err := logging.SetLevel(level); err != nil {
+			return nil, err
+		}
+		slog.Info("log level changed", "level", logging.Level())
+		return "Log level is now " + logging.Level(), nil
+
+	case "config":
+		values := cliUtils.ServerConfig()
+		values["log-level"] = logging.Level()
+		values["admin-socket"] = cliUtils.GetAdminSocketPath()
+		values["pid"] = fmt.Sprint(os.Getpid())
+		return values, nil
+
+	default:
+		return nil, fmt.Errorf("unknown admin subcommand %q, run admin -h for more info", method)
+	}
+}
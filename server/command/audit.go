@@ -0,0 +1,136 @@
+package command
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/audit"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/tlsUtils"
+	"github.com/mitchellh/cli"
+	"log/slog"
+	"strings"
+)
+
+// AuditCommand checks the audit log written by the server
+type AuditCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *AuditCommand) Help() string {
+	help := `
+Usage: [flags] audit verify [options]
+  Checks that no entry of the audit log has been modified, removed or reordered
+  and that the log hasn't been truncated since the server last wrote to it. The
+  first broken entry is reported.
+
+  Every handshake attempt, access control decision and admin action is written
+  to the audit log when audit-log is configured.
+
+Options:
+  --file=path   The audit log to check, audit-log by default.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *AuditCommand) Synopsis() string {
+	return "Verify the audit log"
+}
+
+// Run the actual command
+func (c *AuditCommand) Run(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		c.UI.Error("Expected audit verify, run -h for more info")
+		return BAD_REQUEST
+	}
+
+	var path string
+	cmdFlags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.StringVar(&path, "file", cliUtils.GetAuditLogPath(), "")
+	if err := cmdFlags.Parse(args[1:]); err != nil {
+		return BAD_REQUEST
+	}
+	if path == "" {
+		c.UI.Error("No audit log configured, set audit-log or pass --file")
+		return BAD_REQUEST
+	}
+
+	summary, err := audit.Verify(path)
+	if errors.Is(err, audit.ErrTampered) {
+		c.UI.Error(err.Error())
+		return TAMPERED
+	}
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	if summary.Last == nil {
+		c.UI.Output("Audit log is empty")
+	} else {
+		c.UI.Output(fmt.Sprintf("Audit log intact: %d entries, the last written %s with hash %s",
+			summary.Entries, summary.Last.Time.Format("2006-01-02 15:04:05 MST"), summary.Last.Hash))
+	}
+	if !summary.HeadChecked {
+		c.UI.Warn("No head file found, entries removed from the end of the log can't be detected")
+	}
+	return OK
+}
+
+// authorize records an access control decision in the audit log and returns it
+func (s *server) authorize(c *clientConn, action string, resource string, allowed bool) bool {
+	decision := "denied"
+	if allowed {
+		decision = "allowed"
+	}
+	s.record(c.log, "authorize", map[string]string{
+		logging.ConnID:   c.id,
+		logging.ClientCN: c.identity,
+		"action":         action,
+		"resource":       resource,
+		"decision":       decision,
+	})
+	return allowed
+}
+
+// auditHandshake records a handshake attempt, err is nil when it succeeded. The client's
+// certificate is fingerprinted even when it wasn't trusted.
+func (s *server) auditHandshake(e *endpoint, conn *tls.Conn, id string, logger *slog.Logger, err error) {
+	fields := map[string]string{
+		logging.ConnID:     id,
+		logging.Listener:   e.Name,
+		logging.RemoteAddr: conn.RemoteAddr().String(),
+		"result":           "accepted",
+	}
+
+	state := conn.ConnectionState()
+	certs := state.PeerCertificates
+	if err != nil {
+		fields["result"] = "rejected"
+		fields["reason"] = handshakeFailureReason(err)
+		var verifyErr *tls.CertificateVerificationError
+		if errors.As(err, &verifyErr) {
+			certs = verifyErr.UnverifiedCertificates
+		}
+	} else {
+		fields[logging.TLSVersion] = tls.VersionName(state.Version)
+		fields[logging.Cipher] = tls.CipherSuiteName(state.CipherSuite)
+	}
+	if len(certs) > 0 {
+		fields[logging.ClientCN] = "CN=" + certs[0].Subject.CommonName
+		fields["fingerprint"] = tlsUtils.Fingerprint(certs[0])
+	}
+
+	s.record(logger, "handshake", fields)
+}
+
+// record writes an event to the audit log, a failure is logged but doesn't stop the server
+func (s *server) record(logger *slog.Logger, event string, fields map[string]string) {
+	if err := s.audit.Record(event, fields); err != nil {
+		logger.Error("unable to write the audit log", "event", event, "error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"github.com/mattsurabian/go-tls/server/rpc"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"time"
+)
+
+// How many calls of one connection may run at once, further calls are refused until one
+// of them is answered
+const maxConcurrentCalls = 32
+
+var errTooManyCalls = errors.New("too many calls in progress")
+
+// handleCall runs the handler for a call and sends its reply. Calls run concurrently so
+// a slow handler doesn't hold up other traffic on the connection.
+func (s *server) handleCall(ctx context.Context, c *clientConn, call *protocol.Frame) {
+	id := call.Header(protocol.HeaderID)
+	method := call.Header(protocol.HeaderMethod)
+	peer := rpc.Peer{Identity: c.identity, Addr: c.remoteAddr}
+	if action, ok := s.actions[method]; ok {
+		// the action refuses clients itself, this only puts the decision on record
+		s.authorize(c, "exec", action.Name, action.Allowed(c.identity))
+	}
+
+	start := time.Now()
+	resp, err := s.handlers.Serve(ctx, peer, rpc.Request{Method: method, Payload: call.Body})
+	if err == nil && len(resp.Payload) > protocol.MaxBodySize {
+		err = protocol.ErrBodyTooLarge
+	}
+
+	var reply *protocol.Frame
+	if err != nil {
+		c.log.Warn("call failed", "method", method, logging.Duration, time.Since(start), "error", err)
+		reply = protocol.NewError(err)
+		reply.SetHeader(protocol.HeaderID, id)
+	} else {
+		c.log.Info("call answered", "method", method, logging.Duration, time.Since(start))
+		reply = protocol.NewReply(id, resp.Payload)
+	}
+
+	if err := c.send(reply); err != nil {
+		c.log.Warn("write error", "error", err)
+	}
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// connInfo describes an open client connection to the admin socket
+type connInfo struct {
+	ID            string    `json:"conn_id"`
+	Listener      string    `json:"listener"`
+	ClientCN      string    `json:"client_cn"`
+	RemoteAddr    string    `json:"remote_addr"`
+	TLSVersion    string    `json:"tls_version"`
+	Cipher        string    `json:"cipher"`
+	Opened        time.Time `json:"opened"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+}
+
+// liveConn is an open client connection, counted so its traffic can be reported
+type liveConn struct {
+	info connInfo
+	conn *countingConn
+}
+
+// connRegistry tracks the open client connections by their conn_id
+type connRegistry struct {
+	mu    sync.Mutex
+	conns map[string]*liveConn
+}
+
+func newConnRegistry() *connRegistry {
+	return &connRegistry{conns: map[string]*liveConn{}}
+}
+
+func (r *connRegistry) add(lc *liveConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[lc.info.ID] = lc
+}
+
+func (r *connRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, id)
+}
+
+// list returns the open connections, oldest first
+func (r *connRegistry) list() []connInfo {
+	r.mu.Lock()
+	infos := make([]connInfo, 0, len(r.conns))
+	for _, lc := range r.conns {
+		info := lc.info
+		info.BytesReceived = lc.conn.received.Load()
+		info.BytesSent = lc.conn.sent.Load()
+		infos = append(infos, info)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Opened.Before(infos[j].Opened)
+	})
+	return infos
+}
+
+// kick closes the connection with the given id, reporting the identity it belonged to
+func (r *connRegistry) kick(id string) (string, bool) {
+	r.mu.Lock()
+	lc, ok := r.conns[id]
+	r.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	// the handlers notice the closed connection and clean up after themselves
+	lc.conn.Close()
+	return lc.info.ClientCN, true
+}
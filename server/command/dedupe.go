@@ -0,0 +1,52 @@
+package command
+
+import (
+	"sync"
+)
+
+// dedupeWindow is the number of recent message ids remembered per client identity
+const dedupeWindow = 4096
+
+// dedupeCache remembers recently seen message ids so messages resent by a reconnecting
+// client, whose acknowledgement was lost, are not processed twice
+type dedupeCache struct {
+	mu    sync.Mutex
+	peers map[string]*seenIDs
+}
+
+// seenIDs is a bounded set which forgets the oldest id first
+type seenIDs struct {
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newDedupeCache() *dedupeCache {
+	return &dedupeCache{peers: map[string]*seenIDs{}}
+}
+
+// seen records id for identity and reports whether it had already been recorded
+func (d *dedupeCache) seen(identity, id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	peer, ok := d.peers[identity]
+	if !ok {
+		peer = &seenIDs{ids: map[string]bool{}, order: make([]string, 0, dedupeWindow)}
+		d.peers[identity] = peer
+	}
+
+	if peer.ids[id] {
+		return true
+	}
+
+	if len(peer.order) < dedupeWindow {
+		peer.order = append(peer.order, id)
+	} else {
+		delete(peer.ids, peer.order[peer.next])
+		peer.order[peer.next] = id
+		peer.next = (peer.next + 1) % dedupeWindow
+	}
+	peer.ids[id] = true
+	return false
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/listeners"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"github.com/mattsurabian/go-tls/shared/tlsUtils"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// endpoint is a listener with the credentials its handshakes use
+type endpoint struct {
+	*listeners.Listener
+	creds *tlsUtils.ServerCredentials
+}
+
+// loadEndpoints reads the listeners option, or falls back to host and port, and loads the
+// credentials of every listener
+func loadEndpoints() ([]*endpoint, error) {
+	list := listeners.Default(cliUtils.GetHostAndPort())
+	if path := cliUtils.GetListenersPath(); path != "" {
+		var err error
+		if list, err = listeners.Load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	defaults := tlsUtils.DefaultServerTLSOptions()
+	if err := listeners.CheckSNIUnknown(cliUtils.GetSNIUnknown()); err != nil {
+		return nil, err
+	}
+	defaults.RejectUnknownNames = cliUtils.GetSNIUnknown() == "reject"
+	if path := cliUtils.GetSNICertsPath(); path != "" {
+		certs, err := listeners.LoadCertificates(path)
+		if err != nil {
+			return nil, err
+		}
+		defaults.SNICertificates = sniCertificates(certs)
+	}
+
+	var endpoints []*endpoint
+	for _, l := range list {
+		creds, err := tlsUtils.LoadServerCredentials(tlsOptions(l, defaults))
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %v", l.Name, err)
+		}
+		endpoints = append(endpoints, &endpoint{Listener: l, creds: creds})
+	}
+	return endpoints, nil
+}
+
+// tlsOptions fills in what the listener leaves to the server-wide defaults
+func tlsOptions(l *listeners.Listener, defaults tlsUtils.ServerTLSOptions) tlsUtils.ServerTLSOptions {
+	options := defaults
+	if l.CertPath != "" {
+		options.CertPath, options.KeyPath, options.ChainPath = l.CertPath, l.KeyPath, l.ChainPath
+	}
+	if l.ClientCAPaths != nil {
+		options.ClientCAPaths = l.ClientCAPaths
+	}
+	if l.MinVersion != 0 {
+		options.MinVersion = l.MinVersion
+	}
+	if l.CipherSuites != nil {
+		options.CipherSuites = l.CipherSuites
+	}
+	if l.SNICertificates != nil {
+		options.SNICertificates = sniCertificates(l.SNICertificates)
+	}
+	if l.SNIUnknown != "" {
+		options.RejectUnknownNames = l.SNIUnknown == "reject"
+	}
+	return options
+}
+
+func sniCertificates(certs []*listeners.Certificate) []tlsUtils.SNICertificate {
+	sni := make([]tlsUtils.SNICertificate, 0, len(certs))
+	for _, cert := range certs {
+		sni = append(sni, tlsUtils.SNICertificate{
+			Names:     cert.Names,
+			CertPath:  cert.CertPath,
+			KeyPath:   cert.KeyPath,
+			ChainPath: cert.ChainPath,
+		})
+	}
+	return sni
+}
+
+// recordCertExpiry publishes when the certificates of the endpoint expire, under the
+// option names when it uses the server's defaults
+func (e *endpoint) recordCertExpiry(m *serverMetrics) {
+	options := e.creds.Options()
+	certOption, caOption := "server-tls-cert", "client-ca"
+	if e.CertPath != "" {
+		certOption = e.Name + ".cert"
+	}
+	if e.ClientCAPaths != nil {
+		caOption = e.Name + ".client-ca"
+	}
+	m.recordCertExpiry(certOption, options.CertPath)
+	m.recordCertExpiry(caOption, options.ClientCAPaths...)
+
+	sniOption := "sni-certs"
+	if e.SNICertificates != nil {
+		sniOption = e.Name + ".sni-certs"
+	}
+	for _, sni := range options.SNICertificates {
+		m.recordCertExpiry(sniOption, sni.CertPath)
+	}
+}
+
+// serve accepts clients on the endpoint until the listener fails
+func (s *server) serve(e *endpoint, listener net.Listener) error {
+	slog.Info("listening", "listener", e.Name, "addr", listener.Addr().String(),
+		"handlers", strings.Join(e.HandlerNames(), ", "))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("listener %s: %v", e.Name, err)
+		}
+		go s.handleClient(e, conn)
+	}
+}
+
+// handlerFor names the handler group a conversation starting with the frame belongs to
+func handlerFor(first *protocol.Frame) string {
+	switch first.Type {
+	case protocol.OpenFrame:
+		return listeners.Forward
+	case protocol.ExposeFrame, protocol.AcceptFrame:
+		return listeners.Expose
+	case protocol.PushFrame, protocol.PullFrame:
+		return listeners.Files
+	case protocol.SubscribeFrame:
+		return listeners.Subscribe
+	case protocol.ReceiveFrame:
+		return listeners.Receive
+	default:
+		return listeners.Messages
+	}
+}
+
+// refuse answers a conversation the endpoint doesn't serve with err. Messages and calls
+// are refused one by one until the client hangs up, so clients which would resend them on
+// a new connection learn they were rejected.
+func refuse(c *clientConn, first *protocol.Frame, err error) {
+	frame := first
+	for {
+		switch {
+		case frame.Type == protocol.PingFrame:
+			c.send(protocol.NewPong(frame))
+		case frame.Type == protocol.PongFrame:
+		case frame.Header(protocol.HeaderID) != "":
+			reply := protocol.NewError(err)
+			reply.SetHeader(protocol.HeaderID, frame.Header(protocol.HeaderID))
+			c.send(reply)
+		default:
+			c.send(protocol.NewError(err))
+			return
+		}
+
+		var readErr error
+		if frame, readErr = protocol.ReadFrame(c.reader); readErr != nil {
+			if readErr != io.EOF {
+				c.log.Warn("read error", "error", readErr)
+			}
+			return
+		}
+	}
+}
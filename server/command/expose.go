@@ -0,0 +1,204 @@
+package command
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/acl"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long an inbound connection waits for the exposing client to claim it
+const exposeAcceptTimeout = 10 * time.Second
+
+// pendingConn is an inbound connection on an exposed port waiting for its client to claim it
+type pendingConn struct {
+	conn     net.Conn
+	identity string
+	claimed  chan struct{}
+}
+
+// exposeRegistry tracks inbound connections that have been announced to an exposing client
+type exposeRegistry struct {
+	acl     *acl.List
+	mu      sync.Mutex
+	pending map[string]*pendingConn
+}
+
+func newExposeRegistry(list *acl.List) *exposeRegistry {
+	return &exposeRegistry{
+		acl:     list,
+		pending: map[string]*pendingConn{},
+	}
+}
+
+// handleExpose opens the requested port for an authorized client and announces every
+// connection it accepts over the control connection until the client goes away
+func (s *server) handleExpose(c *clientConn, expose *protocol.Frame) {
+	identity := c.identity
+	port := expose.Header(protocol.HeaderPort)
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		c.send(protocol.NewError(fmt.Errorf("invalid port %q", port)))
+		return
+	}
+	if !s.authorize(c, "expose", port, s.expose.acl.Allowed(identity, port)) {
+		c.log.Warn("expose denied", "port", port)
+		c.send(protocol.NewError(fmt.Errorf("exposing port %s is not allowed", port)))
+		return
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(cliUtils.GetHost(), port))
+	if err != nil {
+		c.log.Warn("expose error", "port", port, "error", err)
+		c.send(protocol.NewError(err))
+		return
+	}
+	defer listener.Close()
+
+	if err := c.send(&protocol.Frame{Type: protocol.OpenedFrame}); err != nil {
+		c.log.Warn("write error", "error", err)
+		return
+	}
+	c.log.Info("expose open", "port", port)
+
+	monitor := startHeartbeat(c, expose, func() error {
+		return c.send(protocol.NewPing())
+	}, func() {
+		c.conn.Close()
+	})
+	defer logHeartbeat(c, monitor)
+
+	// the client only sends heartbeats on the control connection, so a failed read
+	// means it has gone away and the port should be closed
+	go func() {
+		defer listener.Close()
+		for {
+			frame, err := protocol.ReadFrame(c.reader)
+			if err != nil {
+				return
+			}
+			switch frame.Type {
+			case protocol.PingFrame:
+				c.send(protocol.NewPong(frame))
+			case protocol.PongFrame:
+				monitor.Pong(frame.RTT())
+			}
+		}
+	}()
+
+	for {
+		inbound, err := listener.Accept()
+		if err != nil {
+			break
+		}
+
+		id, err := s.expose.add(inbound, identity)
+		if err != nil {
+			c.log.Warn("expose error", "port", port, "error", err)
+			inbound.Close()
+			continue
+		}
+
+		connect := &protocol.Frame{Type: protocol.ConnectFrame}
+		connect.SetHeader(protocol.HeaderID, id)
+		if err := c.send(connect); err != nil {
+			s.expose.abandon(id)
+			break
+		}
+	}
+
+	c.log.Info("expose closed", "port", port)
+}
+
+// handleAccept hands a waiting inbound connection to the client that claims it
+func (s *server) handleAccept(c *clientConn, accept *protocol.Frame) {
+	identity := c.identity
+	id := accept.Header(protocol.HeaderID)
+
+	inbound, err := s.expose.claim(id, identity)
+	if err != nil {
+		c.log.Warn("accept denied", "error", err)
+		c.send(protocol.NewError(err))
+		return
+	}
+
+	if err := c.send(&protocol.Frame{Type: protocol.OpenedFrame}); err != nil {
+		c.log.Warn("write error", "error", err)
+		inbound.Close()
+		return
+	}
+
+	started := time.Now()
+	sent, received := netUtils.Splice(c.conn, c.reader, inbound)
+	c.log.Info("reverse closed", "inbound", inbound.RemoteAddr().String(), logging.Transfer(received, sent),
+		logging.Duration, time.Since(started).Round(time.Millisecond))
+}
+
+// add registers an inbound connection and closes it if it isn't claimed in time
+func (r *exposeRegistry) add(conn net.Conn, identity string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b[:])
+
+	p := &pendingConn{
+		conn:     conn,
+		identity: identity,
+		claimed:  make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	r.pending[id] = p
+	r.mu.Unlock()
+
+	go func() {
+		select {
+		case <-p.claimed:
+		case <-time.After(exposeAcceptTimeout):
+			if r.abandon(id) {
+				slog.Warn("exposed connection was not claimed in time",
+					logging.ClientCN, identity, "inbound", conn.RemoteAddr().String())
+			}
+		}
+	}()
+
+	return id, nil
+}
+
+// claim removes a pending connection, only the identity that exposed the port may claim it
+func (r *exposeRegistry) claim(id string, identity string) (net.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pending[id]
+	if !ok || p.identity != identity {
+		return nil, errors.New("no such pending connection")
+	}
+	delete(r.pending, id)
+	close(p.claimed)
+	return p.conn, nil
+}
+
+// abandon closes a pending connection, reporting whether it was still waiting
+func (r *exposeRegistry) abandon(id string) bool {
+	r.mu.Lock()
+	p, ok := r.pending[id]
+	delete(r.pending, id)
+	r.mu.Unlock()
+
+	if ok {
+		p.conn.Close()
+	}
+	return ok
+}
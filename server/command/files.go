@@ -0,0 +1,163 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/files"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errFilesDisabled = errors.New("file transfer is disabled on this server")
+
+// handlePush receives a file into the store, resuming an earlier upload of the same content
+func (s *server) handlePush(c *clientConn, push *protocol.Frame) {
+	if s.files == nil {
+		c.send(protocol.NewError(errFilesDisabled))
+		return
+	}
+
+	name := push.Header(protocol.HeaderFilename)
+	sum := strings.ToLower(push.Header(protocol.HeaderSHA256))
+	size, err := strconv.ParseInt(push.Header(protocol.HeaderSize), 10, 64)
+	if err != nil || size < 0 {
+		c.send(protocol.NewError(errors.New("invalid size")))
+		return
+	}
+	if err := files.ValidName(name); err != nil {
+		c.log.Warn("push refused", "error", err)
+		c.send(protocol.NewError(err))
+		return
+	}
+	if size > s.maxUpload {
+		c.log.Warn("push refused", "filename", name, "size", size, "max", s.maxUpload)
+		c.send(protocol.NewError(fmt.Errorf("%s is larger than the %d bytes allowed", name, s.maxUpload)))
+		return
+	}
+	// uploads nobody came back for are cleaned up as new ones arrive
+	expirePartials(s.files, s.partialAge, c.log)
+
+	upload, offset, err := s.files.Partial(sum)
+	if err != nil {
+		c.send(protocol.NewError(err))
+		return
+	}
+
+	// a partial upload longer than the file can't be the same content, start over
+	if offset > size {
+		if err := upload.Truncate(0); err == nil {
+			offset, err = upload.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			upload.Close()
+			c.send(protocol.NewError(err))
+			return
+		}
+	}
+
+	opened := &protocol.Frame{Type: protocol.OpenedFrame}
+	opened.SetHeader(protocol.HeaderOffset, strconv.FormatInt(offset, 10))
+	if err := c.send(opened); err != nil {
+		upload.Close()
+		return
+	}
+	c.log.Info("push started", "filename", name, "size", size, "offset", offset)
+
+	received, err := protocol.ReceiveData(c.reader, upload, size-offset)
+	if syncErr := upload.Sync(); err == nil {
+		err = syncErr
+	}
+	upload.Close()
+	if err != nil {
+		c.log.Warn("push interrupted", "filename", name, "offset", offset+received, "error", err)
+		return
+	}
+
+	if err := s.files.Commit(sum, name); err != nil {
+		c.log.Error("push failed", "filename", name, "error", err)
+		c.send(protocol.NewError(err))
+		return
+	}
+
+	c.log.Info("push complete", "filename", name, "sha256", sum)
+	ack := &protocol.Frame{Type: protocol.AckFrame}
+	ack.SetHeader(protocol.HeaderSHA256, sum)
+	c.send(ack)
+}
+
+// expirePartials deletes the partial uploads older than maxAge, zero keeps them
+func expirePartials(store *files.Store, maxAge time.Duration, logger *slog.Logger) {
+	if maxAge <= 0 {
+		return
+	}
+	expired, err := store.ExpirePartials(maxAge)
+	if err != nil {
+		logger.Warn("expiring partial uploads failed", "error", err)
+	} else if expired > 0 {
+		logger.Info("expired partial uploads", "count", expired)
+	}
+}
+
+// handlePull sends a file from the store starting at the requested offset
+func (s *server) handlePull(c *clientConn, pull *protocol.Frame) {
+	if s.files == nil {
+		c.send(protocol.NewError(errFilesDisabled))
+		return
+	}
+
+	name := pull.Header(protocol.HeaderFilename)
+	offset, err := strconv.ParseInt(pull.Header(protocol.HeaderOffset), 10, 64)
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	f, err := s.files.Open(name)
+	if err != nil {
+		c.log.Warn("pull refused", "filename", name, "error", err)
+		c.send(protocol.NewError(errors.New("no such file: " + name)))
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		c.send(protocol.NewError(errors.New("no such file: " + name)))
+		return
+	}
+	size := info.Size()
+
+	// the checksum covers the whole file so the client can verify a resumed download
+	sum, err := files.Checksum(io.LimitReader(f, size))
+	if err != nil {
+		c.send(protocol.NewError(err))
+		return
+	}
+	if offset > size {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		c.send(protocol.NewError(err))
+		return
+	}
+
+	opened := &protocol.Frame{Type: protocol.OpenedFrame}
+	opened.SetHeader(protocol.HeaderSize, strconv.FormatInt(size, 10))
+	opened.SetHeader(protocol.HeaderSHA256, sum)
+	opened.SetHeader(protocol.HeaderOffset, strconv.FormatInt(offset, 10))
+	if err := c.send(opened); err != nil {
+		return
+	}
+
+	// nothing else writes to a transfer, so the data can go straight to the connection
+	sent, err := protocol.SendData(c.conn, io.LimitReader(f, size-offset))
+	if err != nil {
+		c.log.Warn("pull interrupted", "filename", name, "offset", offset+sent, "error", err)
+		return
+	}
+	c.log.Info("pull complete", "filename", name, logging.Bytes, sent, "offset", offset)
+}
@@ -0,0 +1,45 @@
+package command
+
+import (
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"net"
+	"time"
+)
+
+// How long the server waits for a forwarding target to accept a connection
+const forwardDialTimeout = 10 * time.Second
+
+// handleForward checks the target requested by an OpenFrame against the forward-allow
+// list for the client's identity, dials it and carries raw bytes in both directions
+func (s *server) handleForward(c *clientConn, open *protocol.Frame) {
+	identity := c.identity
+	target := open.Header(protocol.HeaderTarget)
+
+	if !s.authorize(c, "forward", target, s.forwardACL.Allowed(identity, target)) {
+		c.log.Warn("forward denied", "target", target)
+		c.send(protocol.NewError(fmt.Errorf("forwarding to %s is not allowed", target)))
+		return
+	}
+
+	remote, err := net.DialTimeout("tcp", target, forwardDialTimeout)
+	if err != nil {
+		c.log.Warn("forward error", "target", target, "error", err)
+		c.send(protocol.NewError(err))
+		return
+	}
+
+	if err := c.send(&protocol.Frame{Type: protocol.OpenedFrame}); err != nil {
+		c.log.Warn("write error", "error", err)
+		remote.Close()
+		return
+	}
+
+	c.log.Info("forward open", "target", target)
+	started := time.Now()
+	sent, received := netUtils.Splice(c.conn, c.reader, remote)
+	c.log.Info("forward closed", "target", target, logging.Transfer(received, sent),
+		logging.Duration, time.Since(started).Round(time.Millisecond))
+}
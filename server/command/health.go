@@ -0,0 +1,195 @@
+package command
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/tlsUtils"
+	"github.com/mitchellh/cli"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How long the health command waits for the server to answer
+const healthTimeout = 5 * time.Second
+
+// HealthCommand asks the server's health endpoints how it is doing
+type HealthCommand struct {
+	UI cli.Ui
+}
+
+// Long-form help
+func (c *HealthCommand) Help() string {
+	help := `
+Usage: [flags] health [options]
+  Asks the health endpoints served on health-listen whether the server is ready:
+  listening for clients, with certificates loaded and unexpired, and able to
+  write to files-dir and the audit log. Exits with 0 when it is, and 503 when
+  it isn't or doesn't answer, so it can serve as a container healthcheck.
+
+  When health-tls is mtls the client-tls-cert, client-tls-key and server-ca
+  options are used to connect.
+
+Options:
+  --live      Only check that the server process is alive.
+  --url=url   The endpoint to ask, derived from health-listen by default.
+`
+	return strings.TrimSpace(help)
+}
+
+func (c *HealthCommand) Synopsis() string {
+	return "Check whether the server is healthy"
+}
+
+// Run the actual command
+func (c *HealthCommand) Run(args []string) int {
+	var live bool
+	var url string
+
+	cmdFlags := flag.NewFlagSet("health", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.UI.Output(c.Help()) }
+	cmdFlags.BoolVar(&live, "live", false, "")
+	cmdFlags.StringVar(&url, "url", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return BAD_REQUEST
+	}
+
+	client := &http.Client{Timeout: healthTimeout}
+	if url == "" {
+		addr := cliUtils.GetHealthListen()
+		if addr == "" {
+			c.UI.Error("No health endpoint configured, set health-listen or pass --url")
+			return BAD_REQUEST
+		}
+		url = healthURL(addr, cliUtils.GetHealthTLS() == "mtls")
+		if live {
+			url += "/healthz"
+		} else {
+			url += "/readyz"
+		}
+	}
+	if strings.HasPrefix(url, "https://") {
+		config, err := tlsUtils.GetClientTLSConfig()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Cannot load client TLS certs or keys: %v", err))
+			return INTERNAL_ERROR
+		}
+		client.Transport = &http.Transport{TLSClientConfig: config}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return UNAVAILABLE
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode != http.StatusOK {
+		c.UI.Error(fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body))))
+		return UNAVAILABLE
+	}
+	c.UI.Output(strings.TrimSpace(string(body)))
+	return OK
+}
+
+// healthURL turns the health-listen address into a URL to reach it on this machine
+func healthURL(addr string, mtls bool) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	if mtls {
+		return "https://" + host
+	}
+	return "http://" + host
+}
+
+// readiness is the answer of /readyz, every check holds "ok" or why it failed
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// checkReadiness tells whether the server can serve clients
+func (s *server) checkReadiness() readiness {
+	checks := map[string]string{
+		"listener":     "ok",
+		"certificates": "ok",
+	}
+	if !s.listening.Load() {
+		checks["listener"] = "not listening yet"
+	}
+	for _, e := range s.endpoints {
+		if notAfter := e.creds.NotAfter(); time.Now().After(notAfter) {
+			checks["certificates"] = fmt.Sprintf("listener %s expired at %s", e.Name, notAfter.Format(time.RFC3339))
+		}
+	}
+	if s.files != nil {
+		checks["files-dir"] = okOrError(s.files.CheckWritable())
+	}
+	if s.audit != nil {
+		checks["audit-log"] = okOrError(s.audit.Err())
+	}
+
+	result := readiness{Ready: true, Checks: checks}
+	for _, check := range checks {
+		if check != "ok" {
+			result.Ready = false
+		}
+	}
+	return result
+}
+
+func okOrError(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// healthHandler serves /healthz, which answers as long as the process runs, and /readyz
+func (s *server) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		result := s.checkReadiness()
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	return mux
+}
+
+// listenHealth binds the health endpoints, over mutual TLS with the same certificates as
+// the first listener when mode is mtls
+func (s *server) listenHealth(addr string, mode string) (net.Listener, error) {
+	switch mode {
+	case "", "plain":
+		return net.Listen("tcp", addr)
+	case "mtls":
+		return tls.Listen("tcp", addr, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.endpoints[0].creds.Config(), nil
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown health-tls %q, expected plain or mtls", mode)
+	}
+}
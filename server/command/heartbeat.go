@@ -0,0 +1,93 @@
+package command
+
+import (
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/heartbeat"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"net"
+	"sync"
+	"time"
+)
+
+// startHeartbeat pings clients that announced on their first frame that they answer
+// pings, older clients and one-shot senders are left alone. It returns nil when
+// heartbeats are disabled for the connection.
+func startHeartbeat(c *clientConn, first *protocol.Frame, ping func() error, onDead func()) *heartbeat.Monitor {
+	if first.Header(protocol.HeaderHeartbeat) == "" {
+		return nil
+	}
+
+	interval := cliUtils.GetHeartbeatInterval()
+	misses := cliUtils.GetHeartbeatMisses()
+	monitor := heartbeat.Start(interval, misses, ping, func() {
+		c.log.Warn("heartbeat missed, closing connection", "misses", misses)
+		onDead()
+	})
+	monitor.OnPong(func(rtt time.Duration) {
+		c.stats.rtt.Observe(rtt.Seconds())
+	})
+	return monitor
+}
+
+// logHeartbeat reports the round trip times measured over the life of a connection
+func logHeartbeat(c *clientConn, m *heartbeat.Monitor) {
+	if m == nil {
+		return
+	}
+	m.Stop()
+
+	stats := m.Stats()
+	if stats.Pongs > 0 {
+		c.log.Info("heartbeat rtt", "last", stats.LastRTT.Round(time.Microsecond),
+			"average", stats.AvgRTT.Round(time.Microsecond), "pings", stats.Pongs)
+	}
+}
+
+// idleConn calls onIdle once no traffic went either way for timeout. It watches connections
+// without heartbeats, such as plain forwards and transfers, which would otherwise stay open
+// forever once the client is gone.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration, onIdle func()) *idleConn {
+	c := &idleConn{Conn: conn, timeout: timeout}
+	if timeout > 0 {
+		c.timer = time.AfterFunc(timeout, onIdle)
+	}
+	return c
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.touch()
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.touch()
+	return n, err
+}
+
+func (c *idleConn) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Reset(c.timeout)
+	}
+}
+
+// stop stops watching the connection, e.g. once heartbeats take over
+func (c *idleConn) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
@@ -0,0 +1,181 @@
+package command
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/mattsurabian/go-tls/server/metrics"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/tlsUtils"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// serverMetrics are the metrics served on the metrics-listen address
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	connectionsActive *metrics.Gauge
+	handshakes        *metrics.CounterVec
+	handshakeSeconds  *metrics.Histogram
+	tlsConnections    *metrics.CounterVec
+	receivedBytes     *metrics.CounterVec
+	sentBytes         *metrics.CounterVec
+	messages          *metrics.CounterVec
+	heartbeatRTT      *metrics.Histogram
+	certExpiry        *metrics.GaugeVec
+}
+
+// connStats are the metrics of one client connection, looked up once when it opens
+type connStats struct {
+	received *metrics.Counter
+	sent     *metrics.Counter
+	messages *metrics.Counter
+	rtt      *metrics.Histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry: r,
+
+		connectionsActive: r.Gauge("gotls_connections_active",
+			"Client connections currently open.").With(),
+		handshakes: r.Counter("gotls_handshakes_total",
+			"TLS handshakes by result, accepted or rejected, and the reason for rejections.", "result", "reason"),
+		handshakeSeconds: r.Histogram("gotls_handshake_duration_seconds",
+			"Time taken by TLS handshakes, successful or not.", metrics.DefaultBuckets).With(),
+		tlsConnections: r.Counter("gotls_tls_connections_total",
+			"Accepted connections by negotiated TLS version and cipher suite.", "version", "cipher"),
+		receivedBytes: r.Counter("gotls_received_bytes_total",
+			"Bytes received from clients after the handshake, by client identity.", "identity"),
+		sentBytes: r.Counter("gotls_sent_bytes_total",
+			"Bytes sent to clients after the handshake, by client identity.", "identity"),
+		messages: r.Counter("gotls_messages_received_total",
+			"Messages received from clients, by client identity.", "identity"),
+		heartbeatRTT: r.Histogram("gotls_heartbeat_rtt_seconds",
+			"Round trip times measured by heartbeats.", metrics.DefaultBuckets).With(),
+		certExpiry: r.Gauge("gotls_certificate_expiry_timestamp_seconds",
+			"Unix time at which each configured certificate expires.", "file", "subject"),
+	}
+}
+
+// forClient resolves the per client metrics of a connection
+func (m *serverMetrics) forClient(identity string) *connStats {
+	return &connStats{
+		received: m.receivedBytes.With(identity),
+		sent:     m.sentBytes.With(identity),
+		messages: m.messages.With(identity),
+		rtt:      m.heartbeatRTT,
+	}
+}
+
+// handshake completes the TLS handshake of a new connection within timeout and records
+// how it went
+func (m *serverMetrics) handshake(conn *tls.Conn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := conn.HandshakeContext(ctx)
+	m.handshakeSeconds.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		m.handshakes.With("rejected", handshakeFailureReason(err)).Inc()
+		return err
+	}
+
+	state := conn.ConnectionState()
+	m.handshakes.With("accepted", "ok").Inc()
+	m.tlsConnections.With(tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)).Inc()
+	return nil
+}
+
+// handshakeFailureReason sorts handshake errors into a few stable label values, the
+// crypto/tls errors themselves aren't exported for most of these
+func handshakeFailureReason(err error) string {
+	var verifyErr *tls.CertificateVerificationError
+	var alert tls.AlertError
+	var netErr net.Error
+	msg := err.Error()
+
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return "client_closed"
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &verifyErr):
+		return "bad_certificate"
+	case strings.Contains(msg, "didn't provide a certificate"):
+		return "no_certificate"
+	case strings.Contains(msg, "unsupported versions") || strings.Contains(msg, "protocol version"):
+		return "protocol_version"
+	case strings.Contains(msg, "cipher suite"):
+		return "no_shared_cipher"
+	case strings.Contains(msg, "unknown server name"):
+		return "unknown_server_name"
+	case strings.Contains(msg, "does not look like a TLS handshake"):
+		return "not_tls"
+	case errors.As(err, &alert) || strings.Contains(msg, "remote error"):
+		// the client gave up, most likely because it didn't trust our certificate
+		return "client_alert"
+	default:
+		return "other"
+	}
+}
+
+// recordCertExpiry publishes when the certificates in configured files or directories
+// expire, option names them in the metric
+func (m *serverMetrics) recordCertExpiry(option string, paths ...string) {
+	if len(paths) == 0 || paths[0] == "" {
+		return
+	}
+	certs, err := tlsUtils.ReadAllCertificates(paths)
+	if err != nil {
+		slog.Warn("unable to read certificate for metrics", "option", option, "error", err)
+		return
+	}
+	for _, cert := range certs {
+		m.certExpiry.With(option, cert.Subject.String()).Set(float64(cert.NotAfter.Unix()))
+	}
+}
+
+// countingConn counts the bytes crossing a connection
+type countingConn struct {
+	net.Conn
+	stats *connStats
+
+	received atomic.Int64
+	sent     atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.stats.received.Add(float64(n))
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.stats.sent.Add(float64(n))
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+// bytes is the log field holding the bytes received and sent so far
+func (c *countingConn) bytes() slog.Attr {
+	return logging.Transfer(c.received.Load(), c.sent.Load())
+}
+
+// CloseWrite keeps half-closing forwarded connections working
+func (c *countingConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection does not support half-closing")
+}
@@ -0,0 +1,71 @@
+package command
+
+import (
+	"github.com/mattsurabian/go-tls/shared/mux"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"io"
+)
+
+// handleMux upgrades a connection to a multiplexed session and serves every stream the
+// client opens on it as if it were a connection of its own
+func (s *server) handleMux(c *clientConn, first *protocol.Frame) {
+	if err := c.send(&protocol.Frame{Type: protocol.OpenedFrame}); err != nil {
+		c.log.Warn("write error", "error", err)
+		return
+	}
+
+	session := mux.Server(netUtils.WithReader(c.conn, c.reader))
+	defer session.Close()
+	c.log.Info("mux session open")
+
+	monitor := startHeartbeat(c, first, session.Ping, func() {
+		session.Close()
+	})
+	session.SetPongHandler(monitor.Pong)
+	defer logHeartbeat(c, monitor)
+
+	streams := 0
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			break
+		}
+		streams++
+		go s.handleStream(stream, c)
+	}
+
+	c.log.Info("mux session closed", "streams", streams)
+}
+
+// handleStream reads the first frame of a multiplexed stream and dispatches it, the
+// stream belongs to the same client as the session
+func (s *server) handleStream(stream *mux.Stream, session *clientConn) {
+	defer stream.Close()
+	logger := session.log.With("stream", stream.ID())
+
+	reader := protocol.NewReader(stream)
+	frame, err := protocol.ReadFrame(reader)
+	if err != nil {
+		if err != io.EOF {
+			logger.Warn("stream read error", "error", err)
+		}
+		return
+	}
+
+	if frame.Type == protocol.MuxFrame {
+		logger.Warn("nested mux sessions are not supported")
+		return
+	}
+
+	s.dispatch(&clientConn{
+		conn:       stream,
+		reader:     reader,
+		id:         session.id,
+		identity:   session.identity,
+		remoteAddr: session.remoteAddr,
+		endpoint:   session.endpoint,
+		stats:      session.stats,
+		log:        logger,
+	}, frame)
+}
@@ -0,0 +1,142 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/pubsub"
+	"github.com/mattsurabian/go-tls/shared/acl"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"sync"
+)
+
+var errPeerOffline = errors.New("peer offline")
+
+// peerRegistry tracks the clients receiving direct messages by their verified identity and,
+// when offline queueing is enabled, holds messages for clients that aren't connected
+type peerRegistry struct {
+	acl        *acl.List
+	inboxes    *pubsub.Broker
+	queueLimit int
+
+	mu      sync.Mutex
+	offline map[string][]*protocol.Frame
+	// the identities that received before, messages are only held for these and those
+	// the access list names so senders can't make up recipients to fill the memory
+	seen map[string]bool
+}
+
+func newPeerRegistry(list *acl.List, inboxes *pubsub.Broker, queueLimit int) *peerRegistry {
+	return &peerRegistry{
+		acl:        list,
+		inboxes:    inboxes,
+		queueLimit: queueLimit,
+		offline:    map[string][]*protocol.Frame{},
+		seen:       map[string]bool{},
+	}
+}
+
+// sendDirect delivers a message to the client named by its to header
+func (s *server) sendDirect(c *clientConn, msg *protocol.Frame) error {
+	to := msg.Header(protocol.HeaderTo)
+	if msg.Header(protocol.HeaderTopic) != "" {
+		return errors.New("a message can't be both published and sent directly")
+	}
+	if !s.authorize(c, "direct", to, s.peers.acl.Allowed(c.identity, to)) {
+		c.log.Warn("direct message denied", "to", to)
+		return fmt.Errorf("messaging %s is not allowed", to)
+	}
+
+	queued, err := s.peers.deliver(to, relayed(msg, c.identity))
+	if err != nil {
+		c.log.Warn("direct message failed", "to", to, "error", err)
+		return err
+	}
+	if queued {
+		c.log.Info("direct message queued until the peer connects", "to", to, logging.Bytes, len(msg.Body))
+	} else {
+		c.log.Info("direct message", "to", to, logging.Bytes, len(msg.Body))
+	}
+	return nil
+}
+
+// handleReceive delivers the direct messages for the client, starting with those that
+// waited for it to connect, until it goes away
+func (s *server) handleReceive(c *clientConn, receive *protocol.Frame) {
+	identity := c.identity
+	if s.peers.acl.Empty() {
+		c.send(protocol.NewError(errors.New("direct messages are disabled on this server")))
+		return
+	}
+
+	sub, backlog := s.peers.connect(identity)
+	delivered := 0
+	defer func() {
+		// the senders were told these were accepted, keep those not written yet
+		unsent := backlog[min(delivered, len(backlog)):]
+		if len(unsent) > 0 {
+			c.log.Info("held messages kept for the next receive", "waiting", len(unsent))
+		}
+		s.peers.disconnect(identity, sub, unsent)
+	}()
+
+	if err := c.send(&protocol.Frame{Type: protocol.OpenedFrame}); err != nil {
+		c.log.Warn("write error", "error", err)
+		return
+	}
+	c.log.Info("receiving", "waiting", len(backlog))
+
+	delivered = s.deliver(c, receive, sub, backlog, c.log)
+	c.log.Info("stopped receiving", "delivered", delivered)
+}
+
+// deliver queues msg for every connection of the client with identity to, or holds it
+// until the client connects. It reports whether the message was held.
+func (r *peerRegistry) deliver(to string, msg *protocol.Frame) (bool, error) {
+	// connect holds the lock too, so a client can't connect between the two steps
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.inboxes.Publish(to, msg) > 0 {
+		return false, nil
+	}
+	if r.queueLimit <= 0 || !(r.seen[to] || r.acl.Names(to)) {
+		return false, errPeerOffline
+	}
+
+	waiting := r.offline[to]
+	if len(waiting) >= r.queueLimit {
+		return false, fmt.Errorf("%v, %d messages are already waiting for it", errPeerOffline, len(waiting))
+	}
+	r.offline[to] = append(waiting, msg)
+	return true, nil
+}
+
+// connect starts receiving messages for identity and returns those that were held for
+// it, which must be delivered first
+func (r *peerRegistry) connect(identity string) (*pubsub.Subscription, []*protocol.Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seen[identity] = true
+	sub := r.inboxes.Subscribe(identity, identity)
+	backlog := r.offline[identity]
+	delete(r.offline, identity)
+	return sub, backlog
+}
+
+// disconnect stops receiving for identity. The unsent messages go to another connection
+// of the client when there is one, or are held again ahead of those which arrived since.
+func (r *peerRegistry) disconnect(identity string, sub *pubsub.Subscription, unsent []*protocol.Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub.Close()
+	for i, msg := range unsent {
+		if r.inboxes.Publish(identity, msg) == 0 {
+			held := append([]*protocol.Frame(nil), unsent[i:]...)
+			r.offline[identity] = append(held, r.offline[identity]...)
+			return
+		}
+	}
+}
@@ -3,5 +3,8 @@ package command
 // Return codes to be used by command implementations and tests
 const (
 	OK             = 0
+	BAD_REQUEST    = 400
+	TAMPERED       = 409
 	INTERNAL_ERROR = 500
+	UNAVAILABLE    = 503
 )
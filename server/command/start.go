@@ -1,16 +1,44 @@
 package command
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/actions"
+	"github.com/mattsurabian/go-tls/server/audit"
+	"github.com/mattsurabian/go-tls/server/files"
+	"github.com/mattsurabian/go-tls/server/pubsub"
+	"github.com/mattsurabian/go-tls/server/rpc"
+	"github.com/mattsurabian/go-tls/shared/acl"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/netUtils"
+	"github.com/mattsurabian/go-tls/shared/protocol"
 	"github.com/mattsurabian/go-tls/shared/tlsUtils"
 	"github.com/mitchellh/cli"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// How long a new connection may take to complete its TLS handshake
+const handshakeTimeout = 10 * time.Second
+
 // StartCommand starts the server application listening on the configured port
 type StartCommand struct {
 	UI cli.Ui
+
+	// Handlers answers calls from clients, rpc.DefaultRegistry is used when nil
+	Handlers *rpc.Registry
 }
 
 // Long-form help
@@ -28,34 +56,451 @@ func (c *StartCommand) Synopsis() string {
 
 // Run the actual command
 func (c *StartCommand) Run(args []string) int {
-	listener := tlsUtils.GetServerTLSListener()
+	forwardACL, err := acl.Load(cliUtils.GetForwardAllowPath())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if forwardACL.Empty() {
+		slog.Info("forwarding disabled, no forward-allow list configured")
+	}
 
-	for {
-		conn, err := listener.Accept()
+	exposeACL, err := acl.Load(cliUtils.GetExposeAllowPath())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if exposeACL.Empty() {
+		slog.Info("reverse tunnels disabled, no expose-allow list configured")
+	}
+
+	publishACL, err := acl.Load(cliUtils.GetPublishAllowPath())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	subscribeACL, err := acl.Load(cliUtils.GetSubscribeAllowPath())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if publishACL.Empty() || subscribeACL.Empty() {
+		slog.Info("topics disabled, publish-allow and subscribe-allow lists are both needed")
+	}
+	policy, err := pubsub.ParsePolicy(cliUtils.GetSlowSubscriberPolicy())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	directACL, err := acl.Load(cliUtils.GetDirectAllowPath())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	if directACL.Empty() {
+		slog.Info("direct messages disabled, no direct-allow list configured")
+	}
+	// direct messages are delivered like messages published to a topic named after the recipient
+	inboxes := pubsub.New(cliUtils.GetSubscriberQueue(), policy)
 
+	var store *files.Store
+	if dir := cliUtils.GetFilesDir(); dir != "" {
+		if store, err = files.Open(dir); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+		expirePartials(store, cliUtils.GetPartialMaxAge(), slog.Default())
+	} else {
+		slog.Info("file transfer disabled, no files-dir configured")
+	}
+
+	handlers := c.Handlers
+	if handlers == nil {
+		handlers = rpc.DefaultRegistry
+	}
+	// the actions are registered on a copy, so they don't leak into the registry we were
+	// given and the command can run again
+	handlers = handlers.Clone()
+
+	execActions, err := actions.Load(cliUtils.GetExecActionsPath())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+	byMethod := map[string]*actions.Action{}
+	for _, action := range execActions {
+		handlers.Register(actions.MethodPrefix+action.Name, action)
+		byMethod[actions.MethodPrefix+action.Name] = action
+	}
+
+	slog.Info("rpc methods", "methods", strings.Join(handlers.Methods(), ", "))
+
+	payloads, err := logging.ParsePayloadMode(cliUtils.GetLogPayloads())
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	endpoints, err := loadEndpoints()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	var auditLog *audit.Log
+	if path := cliUtils.GetAuditLogPath(); path != "" {
+		if auditLog, err = audit.Open(path); err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+		defer auditLog.Close()
+	} else {
+		slog.Info("audit log disabled, no audit-log configured")
+	}
+
+	srv := &server{
+		forwardACL:   forwardACL,
+		expose:       newExposeRegistry(exposeACL),
+		dedupe:       newDedupeCache(),
+		handlers:     handlers,
+		actions:      byMethod,
+		files:        store,
+		maxUpload:    cliUtils.GetMaxUploadSize(),
+		partialAge:   cliUtils.GetPartialMaxAge(),
+		publishACL:   publishACL,
+		subscribeACL: subscribeACL,
+		broker:       pubsub.New(cliUtils.GetSubscriberQueue(), policy),
+		peers:        newPeerRegistry(directACL, inboxes, cliUtils.GetOfflineQueue()),
+		metrics:      newServerMetrics(),
+		payloads:     payloads,
+		audit:        auditLog,
+		endpoints:    endpoints,
+		conns:        newConnRegistry(),
+	}
+	addrs := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		addrs = append(addrs, e.Addr)
+	}
+	if err := auditLog.Record("start", map[string]string{"listen": strings.Join(addrs, " ")}); err != nil {
+		c.UI.Error(err.Error())
+		return INTERNAL_ERROR
+	}
+
+	for _, e := range endpoints {
+		e.recordCertExpiry(srv.metrics)
+	}
+	if addr := cliUtils.GetMetricsListen(); addr != "" {
+		// bind before accepting clients so a bad address fails the start right away
+		metricsListener, err := net.Listen("tcp", addr)
+		if err != nil {
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", srv.metrics.registry)
+		go http.Serve(metricsListener, mux)
+		slog.Info("serving metrics", "url", "http://"+metricsListener.Addr().String()+"/metrics")
+	}
+
+	if addr := cliUtils.GetHealthListen(); addr != "" {
+		healthListener, err := srv.listenHealth(addr, cliUtils.GetHealthTLS())
 		if err != nil {
-			panic(err)
+			c.UI.Error(err.Error())
+			return INTERNAL_ERROR
 		}
+		go http.Serve(healthListener, srv.healthHandler())
+		slog.Info("serving health endpoints", "addr", healthListener.Addr().String(), "tls", cliUtils.GetHealthTLS())
+	}
 
-		log.Println("------------------------------------")
-		log.Println("connection open")
-		go handleClient(conn)
+	// the server is still useful without admin commands, e.g. next to another server run
+	// by the same user, so a socket that can't be bound doesn't stop it
+	adminPath := cliUtils.GetAdminSocketPath()
+	adminListener, err := netUtils.ListenPrivateSocket(adminPath)
+	if errors.Is(err, netUtils.ErrSocketInUse) {
+		slog.Warn("admin socket disabled, another server is listening on it", "path", adminPath)
+	} else if err != nil {
+		slog.Warn("admin socket disabled", "path", adminPath, "error", err)
+	} else {
+		defer adminListener.Close()
+		go srv.serveAdmin(adminListener)
 	}
-	return OK
+
+	// bind every listener before serving any, so one bad address fails the whole start
+	bound := make([]net.Listener, len(endpoints))
+	for i, e := range endpoints {
+		if bound[i], err = tlsUtils.GetServerTLSListener(e.Addr, e.creds); err != nil {
+			c.UI.Error(fmt.Sprintf("listener %s: %v", e.Name, err))
+			return INTERNAL_ERROR
+		}
+		defer bound[i].Close()
+	}
+	srv.listening.Store(true)
+
+	failed := make(chan error, len(endpoints))
+	for i, e := range endpoints {
+		go func(e *endpoint, listener net.Listener) {
+			failed <- srv.serve(e, listener)
+		}(e, bound[i])
+	}
+	c.UI.Error((<-failed).Error())
+	return INTERNAL_ERROR
 }
 
-func handleClient(conn net.Conn) {
+// server holds the state shared by every client connection
+type server struct {
+	forwardACL *acl.List
+	expose     *exposeRegistry
+	dedupe     *dedupeCache
+	handlers   *rpc.Registry
+	actions    map[string]*actions.Action
+	files      *files.Store
+	maxUpload  int64
+	partialAge time.Duration
+
+	publishACL   *acl.List
+	subscribeACL *acl.List
+	broker       *pubsub.Broker
+	peers        *peerRegistry
+	metrics      *serverMetrics
+
+	// how much of message bodies may be logged
+	payloads logging.PayloadMode
+	audit    *audit.Log
+
+	// the listeners clients connect to and the connections they opened, for the admin socket
+	endpoints []*endpoint
+	conns     *connRegistry
+
+	// set once every listener is bound, for the readiness check
+	listening atomic.Bool
+}
+
+// clientConn is one conversation with a client, either a whole TLS connection or a
+// single stream multiplexed over one
+type clientConn struct {
+	conn       io.ReadWriteCloser
+	reader     *bufio.Reader
+	id         string
+	identity   string
+	remoteAddr string
+	endpoint   *endpoint
+	stats      *connStats
+	log        *slog.Logger
+
+	writeMu sync.Mutex
+}
+
+// send writes a frame, it is safe to call from several goroutines
+func (c *clientConn) send(f *protocol.Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return protocol.WriteFrame(c.conn, f)
+}
+
+func (s *server) handleClient(e *endpoint, conn net.Conn) {
 	defer conn.Close()
-	buf := make([]byte, 512)
+	opened := time.Now()
+	id := newConnID()
+	logger := slog.With(logging.ConnID, id, logging.Listener, e.Name, logging.RemoteAddr, conn.RemoteAddr().String())
+
+	tlsConn := conn.(*tls.Conn)
+	err := s.metrics.handshake(tlsConn, handshakeTimeout)
+	s.auditHandshake(e, tlsConn, id, logger, err)
+	if err != nil {
+		logger.Warn("handshake failed", "error", err, logging.Duration, time.Since(opened))
+		return
+	}
+	s.metrics.connectionsActive.Inc()
+	defer s.metrics.connectionsActive.Dec()
+
+	identity := tlsUtils.PeerIdentity(conn)
+	state := tlsConn.ConnectionState()
+	logger = logger.With(
+		logging.ClientCN, identity,
+		logging.TLSVersion, tls.VersionName(state.Version),
+		logging.Cipher, tls.CipherSuiteName(state.CipherSuite),
+	)
+	logger.Info("connection open")
+
+	idleTimeout := cliUtils.GetIdleTimeout()
+	idle := newIdleConn(conn, idleTimeout, func() {
+		logger.Warn("idle, closing connection", "timeout", idleTimeout)
+		conn.Close()
+	})
+	defer idle.stop()
+
+	stats := s.metrics.forClient(identity)
+	counted := &countingConn{Conn: idle, stats: stats}
+	defer func() {
+		logger.Info("connection closed", counted.bytes(), logging.Duration, time.Since(opened))
+	}()
+
+	s.conns.add(&liveConn{
+		info: connInfo{
+			ID:         id,
+			Listener:   e.Name,
+			ClientCN:   identity,
+			RemoteAddr: conn.RemoteAddr().String(),
+			TLSVersion: tls.VersionName(state.Version),
+			Cipher:     tls.CipherSuiteName(state.CipherSuite),
+			Opened:     opened,
+		},
+		conn: counted,
+	})
+	defer s.conns.remove(id)
+
+	// the first frame decides what the connection will be used for
+	reader := protocol.NewReader(counted)
+	frame, err := protocol.ReadFrame(reader)
+	if err != nil {
+		if err != io.EOF {
+			logger.Warn("read error", "error", err)
+		}
+		return
+	}
+	if frame.Header(protocol.HeaderHeartbeat) != "" && cliUtils.GetHeartbeatInterval() > 0 {
+		// the heartbeats find out when the client is gone
+		idle.stop()
+	}
+
+	c := &clientConn{
+		conn:       counted,
+		reader:     reader,
+		id:         id,
+		identity:   identity,
+		remoteAddr: conn.RemoteAddr().String(),
+		endpoint:   e,
+		stats:      stats,
+		log:        logger,
+	}
+
+	if frame.Type == protocol.MuxFrame {
+		s.handleMux(c, frame)
+		return
+	}
+	s.dispatch(c, frame)
+}
+
+// dispatch hands a conversation to the handler matching its first frame
+func (s *server) dispatch(c *clientConn, first *protocol.Frame) {
+	if handler := handlerFor(first); !c.endpoint.Serves(handler) {
+		c.log.Warn("handler not served on this listener", "handler", handler)
+		refuse(c, first, fmt.Errorf("%s is not served on this port", handler))
+		return
+	}
+
+	switch first.Type {
+	case protocol.OpenFrame:
+		s.handleForward(c, first)
+	case protocol.ExposeFrame:
+		s.handleExpose(c, first)
+	case protocol.AcceptFrame:
+		s.handleAccept(c, first)
+	case protocol.PushFrame:
+		s.handlePush(c, first)
+	case protocol.PullFrame:
+		s.handlePull(c, first)
+	case protocol.SubscribeFrame:
+		s.handleSubscribe(c, first)
+	case protocol.ReceiveFrame:
+		s.handleReceive(c, first)
+	default:
+		s.handleMessages(c, first)
+	}
+}
+
+// handleMessages logs every message received on the connection and answers calls,
+// starting with first
+func (s *server) handleMessages(c *clientConn, first *protocol.Frame) {
+	// canceled once the connection is gone, handlers still running can give up early
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	monitor := startHeartbeat(c, first, func() error {
+		return c.send(protocol.NewPing())
+	}, func() {
+		c.conn.Close()
+	})
+	defer logHeartbeat(c, monitor)
+
+	calls := make(chan struct{}, maxConcurrentCalls)
+	frame := first
 	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			break
+		switch frame.Type {
+		case protocol.PingFrame:
+			if err := c.send(protocol.NewPong(frame)); err != nil {
+				c.log.Warn("write error", "error", err)
+				return
+			}
+		case protocol.PongFrame:
+			monitor.Pong(frame.RTT())
+		case protocol.MessageFrame:
+			c.stats.messages.Inc()
+			id := frame.Header(protocol.HeaderID)
+			reply := protocol.NewAck(id)
+			if id != "" && s.dedupe.seen(c.identity, id) {
+				// a resend after a lost acknowledgement, acknowledge it again but don't process it
+				c.log.Info("duplicate message ignored", "id", id)
+			} else if frame.Header(protocol.HeaderTo) != "" {
+				if err := s.sendDirect(c, frame); err != nil {
+					reply = protocol.NewError(err)
+					reply.SetHeader(protocol.HeaderID, id)
+				}
+			} else if frame.Header(protocol.HeaderTopic) != "" {
+				if err := s.publish(c, frame); err != nil {
+					reply = protocol.NewError(err)
+					reply.SetHeader(protocol.HeaderID, id)
+				}
+			} else {
+				// log output for now, eventually we should store this somewhere
+				attrs := logging.Payload(s.payloads, frame.Body)
+				if name := frame.Header(protocol.HeaderFilename); name != "" {
+					attrs = append(attrs, "filename", name)
+				}
+				c.log.Info("received", attrs...)
+			}
+			if id != "" {
+				if err := c.send(reply); err != nil {
+					c.log.Warn("write error", "error", err)
+					return
+				}
+			}
+		case protocol.CallFrame:
+			select {
+			case calls <- struct{}{}:
+				go func(call *protocol.Frame) {
+					defer func() { <-calls }()
+					s.handleCall(ctx, c, call)
+				}(frame)
+			default:
+				c.log.Warn("call refused", "method", frame.Header(protocol.HeaderMethod), "error", errTooManyCalls)
+				reply := protocol.NewError(errTooManyCalls)
+				reply.SetHeader(protocol.HeaderID, frame.Header(protocol.HeaderID))
+				if err := c.send(reply); err != nil {
+					c.log.Warn("write error", "error", err)
+					return
+				}
+			}
+		default:
+			c.log.Warn("unexpected frame, closing connection", "type", frame.Type.String())
+			return
 		}
 
-		// log output for now, eventually we should store this somewhere
-		log.Printf("received: %s\n", buf[:n])
+		var err error
+		frame, err = protocol.ReadFrame(c.reader)
+		if err != nil {
+			if err != io.EOF {
+				c.log.Warn("read error", "error", err)
+			}
+			return
+		}
 	}
-	log.Println("connection closed")
-	log.Println("------------------------------------")
+}
+
+// newConnID returns a short random id telling the log records of one connection apart
+func newConnID() string {
+	id := make([]byte, 6)
+	rand.Read(id)
+	return hex.EncodeToString(id)
 }
@@ -0,0 +1,157 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/pubsub"
+	"github.com/mattsurabian/go-tls/shared/logging"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// How long a subscriber being disconnected has to take the error explaining why
+const slowSubscriberGrace = 5 * time.Second
+
+var errSlowSubscriber = errors.New("fell too far behind, disconnected")
+
+// publish fans a message out to the subscribers of its topic
+func (s *server) publish(c *clientConn, msg *protocol.Frame) error {
+	topic := msg.Header(protocol.HeaderTopic)
+	if err := pubsub.ValidTopic(topic); err != nil {
+		return err
+	}
+	if !s.authorize(c, "publish", topic, s.publishACL.Allowed(c.identity, topic)) {
+		c.log.Warn("publish denied", "topic", topic)
+		return fmt.Errorf("publishing to %s is not allowed", topic)
+	}
+
+	n := s.broker.Publish(topic, relayed(msg, c.identity))
+	c.log.Info("published", "topic", topic, logging.Bytes, len(msg.Body), "subscribers", n)
+	return nil
+}
+
+// relayed returns the copy of msg delivered to other clients, it names the sender and
+// leaves out the id, which only means something to the sender
+func relayed(msg *protocol.Frame, from string) *protocol.Frame {
+	delivery := protocol.NewMessage(msg.Body)
+	for k, v := range msg.Headers {
+		if k != protocol.HeaderID {
+			delivery.SetHeader(k, v)
+		}
+	}
+	delivery.SetHeader(protocol.HeaderFrom, from)
+	return delivery
+}
+
+// handleSubscribe delivers every message published to the requested topic until the
+// client goes away or falls too far behind
+func (s *server) handleSubscribe(c *clientConn, subscribe *protocol.Frame) {
+	identity := c.identity
+	topic := subscribe.Header(protocol.HeaderTopic)
+
+	if err := pubsub.ValidTopic(topic); err != nil {
+		c.send(protocol.NewError(err))
+		return
+	}
+	if !s.authorize(c, "subscribe", topic, s.subscribeACL.Allowed(identity, topic)) {
+		c.log.Warn("subscribe denied", "topic", topic)
+		c.send(protocol.NewError(fmt.Errorf("subscribing to %s is not allowed", topic)))
+		return
+	}
+
+	sub := s.broker.Subscribe(topic, identity)
+	defer sub.Close()
+
+	if err := c.send(&protocol.Frame{Type: protocol.OpenedFrame}); err != nil {
+		c.log.Warn("write error", "error", err)
+		return
+	}
+	c.log.Info("subscribed", "topic", topic)
+
+	delivered := s.deliver(c, subscribe, sub, nil, c.log.With("topic", topic))
+	c.log.Info("unsubscribed", "topic", topic, "delivered", delivered)
+}
+
+// deliver writes backlog and then the messages queued for sub to the client until it
+// goes away or falls too far behind, returning how many were written. logger describes
+// the delivery in the log.
+func (s *server) deliver(c *clientConn, first *protocol.Frame, sub *pubsub.Subscription, backlog []*protocol.Frame, logger *slog.Logger) int {
+	monitor := startHeartbeat(c, first, func() error {
+		return c.send(protocol.NewPing())
+	}, func() {
+		c.conn.Close()
+	})
+	defer logHeartbeat(c, monitor)
+
+	// receivers only send heartbeats, so a failed read means the client has gone away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			frame, err := protocol.ReadFrame(c.reader)
+			if err != nil {
+				return
+			}
+			switch frame.Type {
+			case protocol.PingFrame:
+				c.send(protocol.NewPong(frame))
+			case protocol.PongFrame:
+				monitor.Pong(frame.RTT())
+			}
+		}
+	}()
+
+	// a write stuck on a stalled client's full socket would keep it around forever
+	go func() {
+		select {
+		case <-sub.Kicked():
+			time.Sleep(slowSubscriberGrace)
+			c.conn.Close()
+		case <-gone:
+		}
+	}()
+
+	delivered := 0
+	for _, msg := range backlog {
+		if err := c.send(msg); err != nil {
+			logger.Warn("write error", "error", err)
+			c.conn.Close()
+			<-gone
+			return delivered
+		}
+		delivered++
+	}
+
+	for {
+		select {
+		case msg := <-sub.C():
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				logger.Warn("messages dropped for a slow client", "dropped", dropped)
+				// the queued frame is shared with the other subscribers, so copy it
+				copied := protocol.NewMessage(msg.Body)
+				for k, v := range msg.Headers {
+					copied.SetHeader(k, v)
+				}
+				copied.SetHeader(protocol.HeaderDropped, strconv.Itoa(dropped))
+				msg = copied
+			}
+			if err := c.send(msg); err != nil {
+				logger.Warn("write error", "error", err)
+				c.conn.Close()
+				<-gone
+				return delivered
+			}
+			delivered++
+		case <-sub.Kicked():
+			logger.Warn("client too slow, disconnecting")
+			c.send(protocol.NewError(errSlowSubscriber))
+			c.conn.Close()
+			<-gone
+			return delivered
+		case <-gone:
+			return delivered
+		}
+	}
+}
@@ -21,6 +21,21 @@ func init() {
 				UI: ui,
 			}, nil
 		},
+		"admin": func() (cli.Command, error) {
+			return &command.AdminCommand{
+				UI: ui,
+			}, nil
+		},
+		"audit": func() (cli.Command, error) {
+			return &command.AuditCommand{
+				UI: ui,
+			}, nil
+		},
+		"health": func() (cli.Command, error) {
+			return &command.HealthCommand{
+				UI: ui,
+			}, nil
+		},
 		"start": func() (cli.Command, error) {
 			return &command.StartCommand{
 				UI: ui,
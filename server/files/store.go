@@ -0,0 +1,246 @@
+/**
+ * files
+ * This package stores files pushed by clients in a single directory and serves them back.
+ * Names are relative slash separated paths inside the directory, names which would
+ * escape it or address hidden files are refused, and every access goes through an
+ * os.Root so a symlink inside the directory can't lead outside of it either.
+ *
+ * Uploads are written to a partial file named after their SHA-256, so an interrupted
+ * upload of the same content resumes where it stopped. The partial file only replaces
+ * the named file once its checksum matches, partial files nobody resumes are deleted
+ * by ExpirePartials.
+ */
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrChecksum = errors.New("files: checksum mismatch")
+	ErrBusy     = errors.New("files: the same content is already being uploaded")
+)
+
+// partialDir holds uploads in progress, it is hidden so clients can't address it
+const partialDir = ".partial"
+
+// Store is a directory of files shared by the clients
+type Store struct {
+	root *os.Root
+
+	mu        sync.Mutex
+	uploading map[string]bool
+}
+
+// Upload is a partial upload being appended to, only one may be open per checksum
+type Upload struct {
+	*os.File
+	store *Store
+	sum   string
+}
+
+// Close closes the partial file, the upload can be resumed later
+func (u *Upload) Close() error {
+	u.store.mu.Lock()
+	delete(u.store.uploading, u.sum)
+	u.store.mu.Unlock()
+	return u.File.Close()
+}
+
+/**
+ * Open
+ * Opens the store in dir, creating the directory if necessary.
+ */
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := root.MkdirAll(partialDir, 0750); err != nil {
+		root.Close()
+		return nil, err
+	}
+	return &Store{root: root, uploading: map[string]bool{}}, nil
+}
+
+/**
+ * CheckWritable
+ * Writes and removes a small file to check that uploads can be stored.
+ */
+func (s *Store) CheckWritable() error {
+	name := path.Join(partialDir, ".writable")
+	f, err := s.root.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("ok"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := s.root.Remove(name); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+/**
+ * ValidName
+ * Checks that name is a relative path which stays inside the store and has no hidden
+ * components.
+ */
+func ValidName(name string) error {
+	if name == "" || strings.Contains(name, "\\") || path.Clean(name) != name {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return fmt.Errorf("invalid file name %q", name)
+		}
+	}
+	return nil
+}
+
+/**
+ * Partial
+ * Opens the partial upload of the content with the given checksum for appending and
+ * returns how many bytes of it are already stored. The upload must be closed before
+ * it can be committed or opened again.
+ */
+func (s *Store) Partial(sum string) (*Upload, int64, error) {
+	if !validSum(sum) {
+		return nil, 0, fmt.Errorf("invalid sha256 %q", sum)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.uploading[sum] {
+		return nil, 0, ErrBusy
+	}
+
+	f, err := s.root.OpenFile(path.Join(partialDir, sum), os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	s.uploading[sum] = true
+	return &Upload{File: f, store: s, sum: sum}, offset, nil
+}
+
+/**
+ * ExpirePartials
+ * Deletes the partial uploads which weren't written to for maxAge, except those being
+ * uploaded right now, and returns how many were deleted.
+ */
+func (s *Store) ExpirePartials(maxAge time.Duration) (int, error) {
+	dir, err := s.root.Open(partialDir)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := dir.ReadDir(-1)
+	dir.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := time.Now().Add(-maxAge)
+	expired := 0
+	for _, entry := range entries {
+		if !validSum(entry.Name()) || s.uploading[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := s.root.Remove(path.Join(partialDir, entry.Name())); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+/**
+ * Commit
+ * Verifies the partial upload against sum and moves it into place as name. A partial
+ * upload which doesn't match is deleted so the next attempt starts over.
+ */
+func (s *Store) Commit(sum string, name string) error {
+	if err := ValidName(name); err != nil {
+		return err
+	}
+	if !validSum(sum) {
+		return fmt.Errorf("invalid sha256 %q", sum)
+	}
+	partial := path.Join(partialDir, sum)
+
+	f, err := s.root.Open(partial)
+	if err != nil {
+		return err
+	}
+	actual, err := Checksum(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if actual != sum {
+		s.root.Remove(partial)
+		return ErrChecksum
+	}
+
+	if dir := path.Dir(name); dir != "." {
+		if err := s.root.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+	}
+	return s.root.Rename(partial, name)
+}
+
+/**
+ * Open
+ * Opens a stored file for reading.
+ */
+func (s *Store) Open(name string) (*os.File, error) {
+	if err := ValidName(name); err != nil {
+		return nil, err
+	}
+	return s.root.Open(name)
+}
+
+/**
+ * Checksum
+ * Returns the hex encoded SHA-256 of everything read from r.
+ */
+func Checksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func validSum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil
+}
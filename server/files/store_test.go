@@ -0,0 +1,173 @@
+package files
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"report.csv", "builds/v1.2/app.tar.gz"} {
+		if err := ValidName(name); err != nil {
+			t.Errorf("Expected %q to be valid, Got: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "/etc/passwd", "../secret", "a/../../b", "a//b", "a/", ".partial/x", "dir/.hidden", `..\windows`, "./a"} {
+		if err := ValidName(name); err == nil {
+			t.Errorf("Expected %q to be refused", name)
+		}
+	}
+}
+
+func TestResumeAndCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+
+	content := "hello resumable world"
+	sum, _ := Checksum(strings.NewReader(content))
+
+	// the first attempt stops half way
+	f, offset, err := store.Partial(sum)
+	if err != nil || offset != 0 {
+		t.Fatalf("Expected an empty partial upload, Got: %d, %v", offset, err)
+	}
+	f.Write([]byte(content[:10]))
+
+	if _, _, err := store.Partial(sum); err != ErrBusy {
+		t.Errorf("Expected a concurrent upload to be refused, Got: %v", err)
+	}
+	f.Close()
+
+	f, offset, err = store.Partial(sum)
+	if err != nil || offset != 10 {
+		t.Fatalf("Expected to resume at 10, Got: %d, %v", offset, err)
+	}
+	f.Write([]byte(content[10:]))
+	f.Close()
+
+	if err := store.Commit(sum, "docs/hello.txt"); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	stored, _ := ioutil.ReadFile(filepath.Join(dir, "docs", "hello.txt"))
+	if string(stored) != content {
+		t.Errorf("Stored file corrupted, Got: %q", stored)
+	}
+}
+
+func TestChecksumMismatchDiscardsPartial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, _ := Open(dir)
+	sum, _ := Checksum(strings.NewReader("expected"))
+
+	f, _, _ := store.Partial(sum)
+	f.Write([]byte("something else"))
+	f.Close()
+
+	if err := store.Commit(sum, "x.txt"); err != ErrChecksum {
+		t.Errorf("Expected ErrChecksum, Got: %v", err)
+	}
+	if _, offset, _ := store.Partial(sum); offset != 0 {
+		t.Errorf("Expected the partial upload to start over, Got offset: %d", offset)
+	}
+}
+
+func TestExpirePartials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+
+	partial := func(content string, age time.Duration) string {
+		sum, _ := Checksum(strings.NewReader(content))
+		f, _, err := store.Partial(sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content[:2]))
+		f.Close()
+		old := time.Now().Add(-age)
+		os.Chtimes(filepath.Join(dir, partialDir, sum), old, old)
+		return sum
+	}
+	abandoned := partial("abandoned upload", 2*time.Hour)
+	recent := partial("recent upload", time.Minute)
+	inProgress := partial("upload in progress", 2*time.Hour)
+	upload, _, err := store.Partial(inProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upload.Close()
+
+	expired, err := store.ExpirePartials(time.Hour)
+	if err != nil || expired != 1 {
+		t.Fatalf("Expected one partial upload to expire, Got: %d, %v", expired, err)
+	}
+	for sum, kept := range map[string]bool{abandoned: false, recent: true, inProgress: true} {
+		if _, err := os.Stat(filepath.Join(dir, partialDir, sum)); (err == nil) != kept {
+			t.Errorf("Partial upload %s kept: %t, Expected: %t", sum, err == nil, kept)
+		}
+	}
+}
+
+func TestSymlinkEscape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, _ := Open(dir)
+	os.Symlink("/etc", filepath.Join(dir, "etc"))
+	if f, err := store.Open("etc/hostname"); err == nil {
+		f.Close()
+		t.Error("Expected a symlink leaving the store to be refused")
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Error opening store: %v", err)
+	}
+	if err := store.CheckWritable(); err != nil {
+		t.Errorf("Expected the store to be writable, Got: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, partialDir)); len(entries) != 0 {
+		t.Errorf("Expected the check to clean up after itself, Got: %v", entries)
+	}
+
+	// a partial directory replaced by a file can't take uploads
+	os.RemoveAll(filepath.Join(dir, partialDir))
+	ioutil.WriteFile(filepath.Join(dir, partialDir), nil, 0640)
+	if err := store.CheckWritable(); err == nil {
+		t.Error("Expected the check to fail without a partial directory")
+	}
+}
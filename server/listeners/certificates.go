@@ -0,0 +1,66 @@
+package listeners
+
+import (
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/sections"
+	"path/filepath"
+	"strings"
+)
+
+// Certificate is a certificate served to clients asking for one of its names with SNI
+type Certificate struct {
+	Name      string
+	Names     []string
+	CertPath  string
+	KeyPath   string
+	ChainPath string
+}
+
+/**
+ * LoadCertificates
+ * Reads a file of SNI certificates, one section per certificate:
+ *
+ *  [api]
+ *  cert  = api.crt
+ *  key   = api.key
+ *  names = api.example.com *.api.example.com
+ *
+ * Names are optional, the DNS names in the certificate are served when they are left out.
+ * A cert-chain file of intermediates may follow the cert, as for the server's certificate.
+ * Relative paths are taken from the file's directory.
+ */
+func LoadCertificates(filePath string) ([]*Certificate, error) {
+	secs, err := sections.Read(filePath, "certificate")
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filePath)
+
+	var certs []*Certificate
+	for _, section := range secs {
+		current := &Certificate{Name: section.Name}
+		certs = append(certs, current)
+		for _, entry := range section.Entries {
+			key, value, lineNumber := entry.Key, entry.Value, entry.Line
+			switch key {
+			case "cert":
+				current.CertPath = resolve(dir, value)
+			case "key":
+				current.KeyPath = resolve(dir, value)
+			case "cert-chain":
+				current.ChainPath = resolve(dir, value)
+			case "names":
+				current.Names = strings.Fields(value)
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key %q", filePath, lineNumber, key)
+			}
+		}
+	}
+
+	for _, cert := range certs {
+		if cert.CertPath == "" || cert.KeyPath == "" {
+			return nil, fmt.Errorf("%s: certificate %s needs both a cert and a key", filePath, cert.Name)
+		}
+	}
+	return certs, nil
+}
@@ -0,0 +1,257 @@
+/**
+ * listeners
+ * This package reads the ports a server listens on from a file of sections, one per
+ * listener:
+ *
+ *  # messages and calls from every client, on the default certificate
+ *  [public]
+ *  listen   = 0.0.0.0:8443
+ *  handlers = messages subscribe receive
+ *
+ *  # forwarding only, for clients signed by the ops CA
+ *  [tunnels]
+ *  listen      = 10.0.0.5:9443
+ *  cert        = tunnels.crt
+ *  key         = tunnels.key
+ *  client-ca   = ops-ca.crt, ops-ca.d
+ *  min-version = 1.3
+ *  handlers    = forward expose
+ *
+ * Only listen is required. Cert, key and client-ca default to server-tls-cert,
+ * server-tls-key and the client-ca option, and sni-certs and sni-unknown to the options
+ * of the same name. Cert-chain goes with cert. Relative paths are taken from the file's
+ * directory. Min-version is 1.2 or 1.3 and ciphers lists the TLS 1.2 cipher suites
+ * allowed by their Go names. Handlers lists the kinds of conversations served, all of
+ * them when it is left out or holds "all".
+ */
+package listeners
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/mattsurabian/go-tls/server/sections"
+	"path/filepath"
+	"strings"
+)
+
+// The handler groups a listener may serve
+const (
+	Messages  = "messages"  // messages, topic publishing, direct messages and calls
+	Forward   = "forward"   // port forwarding
+	Expose    = "expose"    // reverse tunnels
+	Files     = "files"     // pushing and pulling files
+	Subscribe = "subscribe" // topic subscriptions
+	Receive   = "receive"   // direct message inboxes
+)
+
+// systemCAs stands for the operating system's CAs in the options, they can't verify clients
+const systemCAs = "system"
+
+// Handlers lists every handler group
+var Handlers = []string{Messages, Forward, Expose, Files, Subscribe, Receive}
+
+// Listener is one address the server accepts clients on. Empty paths, a zero MinVersion
+// and nil CipherSuites leave the server's defaults in place.
+type Listener struct {
+	Name string
+	Addr string
+
+	CertPath      string
+	KeyPath       string
+	ChainPath     string
+	ClientCAPaths []string
+	MinVersion    uint16
+	CipherSuites  []uint16
+
+	// SNICertificates are nil when the listener has no sni-certs of its own
+	SNICertificates []*Certificate
+	SNIUnknown      string
+
+	handlers map[string]bool
+}
+
+/**
+ * Default
+ * Returns the single listener serving everything on addr, used when no listeners file is
+ * configured.
+ */
+func Default(addr string) []*Listener {
+	return []*Listener{{Name: "default", Addr: addr, handlers: all()}}
+}
+
+/**
+ * Load
+ * Reads the listeners file at filePath.
+ */
+func Load(filePath string) ([]*Listener, error) {
+	secs, err := sections.Read(filePath, "listener")
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filePath)
+
+	var listeners []*Listener
+	for _, section := range secs {
+		current := &Listener{Name: section.Name, handlers: all()}
+		listeners = append(listeners, current)
+		for _, entry := range section.Entries {
+			key, value, lineNumber := entry.Key, entry.Value, entry.Line
+			switch key {
+			case "listen":
+				current.Addr = value
+			case "cert":
+				current.CertPath = resolve(dir, value)
+			case "key":
+				current.KeyPath = resolve(dir, value)
+			case "cert-chain":
+				current.ChainPath = resolve(dir, value)
+			case "client-ca":
+				current.ClientCAPaths = nil
+				for _, path := range strings.FieldsFunc(value, isListSeparator) {
+					if path == systemCAs {
+						return nil, fmt.Errorf("%s:%d: the system's CAs can't verify client certificates", filePath, lineNumber)
+					}
+					current.ClientCAPaths = append(current.ClientCAPaths, resolve(dir, path))
+				}
+			case "min-version":
+				switch value {
+				case "1.2":
+					current.MinVersion = tls.VersionTLS12
+				case "1.3":
+					current.MinVersion = tls.VersionTLS13
+				default:
+					return nil, fmt.Errorf("%s:%d: invalid min-version %q, expected 1.2 or 1.3", filePath, lineNumber, value)
+				}
+			case "ciphers":
+				current.CipherSuites, err = parseCipherSuites(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+				}
+			case "sni-certs":
+				current.SNICertificates, err = LoadCertificates(resolve(dir, value))
+				if err != nil {
+					return nil, err
+				}
+			case "sni-unknown":
+				if err := CheckSNIUnknown(value); err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+				}
+				current.SNIUnknown = value
+			case "handlers":
+				current.handlers, err = parseHandlers(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", filePath, lineNumber, err)
+				}
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown key %q", filePath, lineNumber, key)
+			}
+		}
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("%s: no listeners declared", filePath)
+	}
+	addrs := map[string]string{}
+	for _, l := range listeners {
+		if l.Addr == "" {
+			return nil, fmt.Errorf("%s: listener %s has no listen address", filePath, l.Name)
+		}
+		if other, ok := addrs[l.Addr]; ok {
+			return nil, fmt.Errorf("%s: listeners %s and %s both listen on %s", filePath, other, l.Name, l.Addr)
+		}
+		addrs[l.Addr] = l.Name
+		if (l.CertPath == "") != (l.KeyPath == "") {
+			return nil, fmt.Errorf("%s: listener %s needs both a cert and a key", filePath, l.Name)
+		}
+		if l.ChainPath != "" && l.CertPath == "" {
+			return nil, fmt.Errorf("%s: listener %s has a cert-chain but no cert", filePath, l.Name)
+		}
+	}
+	return listeners, nil
+}
+
+// CheckSNIUnknown validates what to do with clients asking for a name without a certificate
+func CheckSNIUnknown(value string) error {
+	switch value {
+	case "default", "reject":
+		return nil
+	default:
+		return fmt.Errorf("invalid sni-unknown %q, expected default or reject", value)
+	}
+}
+
+// Serves reports whether the listener serves the handler group
+func (l *Listener) Serves(handler string) bool {
+	return l.handlers[handler]
+}
+
+// HandlerNames lists the handler groups the listener serves
+func (l *Listener) HandlerNames() []string {
+	var names []string
+	for _, handler := range Handlers {
+		if l.handlers[handler] {
+			names = append(names, handler)
+		}
+	}
+	return names
+}
+
+func all() map[string]bool {
+	handlers := map[string]bool{}
+	for _, handler := range Handlers {
+		handlers[handler] = true
+	}
+	return handlers
+}
+
+func parseHandlers(value string) (map[string]bool, error) {
+	handlers := map[string]bool{}
+	for _, name := range strings.Fields(value) {
+		if name == "all" {
+			return all(), nil
+		}
+		known := false
+		for _, handler := range Handlers {
+			known = known || handler == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown handler %q, expected some of %s", name, strings.Join(Handlers, ", "))
+		}
+		handlers[name] = true
+	}
+	if len(handlers) == 0 {
+		return nil, fmt.Errorf("no handlers listed")
+	}
+	return handlers, nil
+}
+
+func parseCipherSuites(value string) ([]uint16, error) {
+	byName := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Fields(value) {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	if len(suites) == 0 {
+		return nil, fmt.Errorf("no cipher suites listed")
+	}
+	return suites, nil
+}
+
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t'
+}
+
+func resolve(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,106 @@
+package listeners
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	list, err := Load("testdata/listeners.conf")
+	if err != nil {
+		t.Fatalf("Error loading listeners: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 listeners, Got: %d", len(list))
+	}
+
+	public, tunnels := list[0], list[1]
+	if public.Name != "public" || public.Addr != "127.0.0.1:8443" {
+		t.Errorf("Unexpected first listener: %+v", public)
+	}
+	if public.CertPath != "" || public.MinVersion != 0 || public.CipherSuites != nil {
+		t.Errorf("Expected the first listener to keep the defaults, Got: %+v", public)
+	}
+	if got := public.HandlerNames(); !reflect.DeepEqual(got, []string{Messages, Subscribe, Receive}) {
+		t.Errorf("Unexpected handlers: %v", got)
+	}
+	if public.Serves(Forward) {
+		t.Error("Expected the first listener not to serve forwarding")
+	}
+
+	if tunnels.CertPath != filepath.Join("testdata", "tunnels.crt") || tunnels.KeyPath != "/etc/go-tls/tunnels.key" {
+		t.Errorf("Expected relative paths to be resolved against the file, Got: %s and %s", tunnels.CertPath, tunnels.KeyPath)
+	}
+	if tunnels.ChainPath != filepath.Join("testdata", "tunnels-chain.pem") {
+		t.Errorf("Unexpected cert-chain: %s", tunnels.ChainPath)
+	}
+	if !reflect.DeepEqual(tunnels.ClientCAPaths, []string{filepath.Join("testdata", "ops-ca.crt"), filepath.Join("testdata", "ops-ca.d")}) {
+		t.Errorf("Unexpected client CAs: %v", tunnels.ClientCAPaths)
+	}
+	if tunnels.MinVersion != tls.VersionTLS13 || len(tunnels.CipherSuites) != 2 {
+		t.Errorf("Unexpected TLS policy: %+v", tunnels)
+	}
+	if len(tunnels.SNICertificates) != 2 || tunnels.SNIUnknown != "reject" {
+		t.Errorf("Unexpected SNI settings: %+v", tunnels)
+	}
+	if !tunnels.Serves(Forward) || !tunnels.Serves(Expose) || tunnels.Serves(Messages) {
+		t.Errorf("Unexpected handlers: %v", tunnels.HandlerNames())
+	}
+}
+
+func TestLoadCertificates(t *testing.T) {
+	certs, err := LoadCertificates("testdata/sni.conf")
+	if err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("Expected 2 certificates, Got: %d", len(certs))
+	}
+	if certs[0].CertPath != filepath.Join("testdata", "api.crt") || certs[0].Names != nil {
+		t.Errorf("Expected a relative path and no names, Got: %+v", certs[0])
+	}
+	if certs[1].ChainPath != filepath.Join("testdata", "other-chain.pem") {
+		t.Errorf("Unexpected cert-chain: %s", certs[1].ChainPath)
+	}
+	if !reflect.DeepEqual(certs[1].Names, []string{"other.example.com", "*.other.example.com"}) {
+		t.Errorf("Unexpected names: %v", certs[1].Names)
+	}
+}
+
+func TestDefaultServesEverything(t *testing.T) {
+	list := Default(":8443")
+	if len(list) != 1 || !reflect.DeepEqual(list[0].HandlerNames(), Handlers) {
+		t.Errorf("Expected one listener serving every handler, Got: %+v", list)
+	}
+}
+
+func TestInvalidFiles(t *testing.T) {
+	invalid := map[string]string{
+		"no listen":       "[a]\nhandlers = all\n",
+		"same address":    "[a]\nlisten = :1\n[b]\nlisten = :1\n",
+		"bad handler":     "[a]\nlisten = :1\nhandlers = gopher\n",
+		"bad version":     "[a]\nlisten = :1\nmin-version = 1.0\n",
+		"bad cipher":      "[a]\nlisten = :1\nciphers = TLS_RSA_WITH_RC4_128_SHA\n",
+		"cert, no key":    "[a]\nlisten = :1\ncert = a.crt\n",
+		"chain, no cert":  "[a]\nlisten = :1\ncert-chain = chain.pem\n",
+		"unknown key":     "[a]\nlisten = :1\nport = 1\n",
+		"no section":      "listen = :1\n",
+		"empty":           "# nothing\n",
+		"duplicate name":  "[a]\nlisten = :1\n[a]\nlisten = :2\n",
+		"bad sni-unknown": "[a]\nlisten = :1\nsni-unknown = ignore\n",
+		"system CAs":      "[a]\nlisten = :1\nclient-ca = ca.crt, system\n",
+	}
+	dir := t.TempDir()
+	for name, content := range invalid {
+		path := filepath.Join(dir, "listeners.conf")
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+/**
+ * metrics
+ * This package collects counters, gauges and histograms and writes them in the Prometheus
+ * text exposition format, so the server can be scraped without a client library. Every
+ * metric is a family of children told apart by their label values, a metric without
+ * labels has a single child. Children are created on first use and live for as long as
+ * the Registry, so label values should come from a small set such as client identities.
+ */
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets suit latencies from a millisecond up to ten seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metric families served together
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu       sync.Mutex
+	children map[string]*child
+}
+
+type child struct {
+	values []string
+
+	// counters and gauges
+	bits uint64
+
+	// histograms
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// CounterVec is a family of counters, values only ever go up
+type CounterVec struct{ f *family }
+
+// GaugeVec is a family of gauges, values may go up and down
+type GaugeVec struct{ f *family }
+
+// HistogramVec is a family of histograms counting observations into buckets
+type HistogramVec struct{ f *family }
+
+// Counter is one child of a CounterVec
+type Counter struct{ c *child }
+
+// Gauge is one child of a GaugeVec
+type Gauge struct{ c *child }
+
+// Histogram is one child of a HistogramVec
+type Histogram struct {
+	c       *child
+	buckets []float64
+}
+
+/**
+ * NewRegistry
+ * Creates an empty Registry.
+ */
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+/**
+ * Counter
+ * Registers a counter family with the given label names.
+ */
+func (r *Registry) Counter(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labels, nil)}
+}
+
+/**
+ * Gauge
+ * Registers a gauge family with the given label names.
+ */
+func (r *Registry) Gauge(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labels, nil)}
+}
+
+/**
+ * Histogram
+ * Registers a histogram family with the given upper bucket bounds, which must be sorted,
+ * and label names. The +Inf bucket is implied.
+ */
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	return &HistogramVec{r.register(name, help, "histogram", labels, buckets)}
+}
+
+func (r *Registry) register(name string, help string, kind string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			panic("metrics: " + name + " registered twice")
+		}
+	}
+	f := &family{
+		name:     name,
+		help:     help,
+		kind:     kind,
+		labels:   labels,
+		buckets:  buckets,
+		children: map[string]*child{},
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+/**
+ * With
+ * Returns the counter for the given label values, one for each label name.
+ */
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{v.f.child(values)}
+}
+
+/**
+ * With
+ * Returns the gauge for the given label values, one for each label name.
+ */
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{v.f.child(values)}
+}
+
+/**
+ * With
+ * Returns the histogram for the given label values, one for each label name.
+ */
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{c: v.f.child(values), buckets: v.f.buckets}
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta to the counter, negative values are ignored
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.c.add(delta)
+	}
+}
+
+// Set replaces the value of the gauge
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.c.bits, math.Float64bits(value))
+}
+
+// Add adds delta, which may be negative, to the gauge
+func (g *Gauge) Add(delta float64) {
+	g.c.add(delta)
+}
+
+// Inc adds one to the gauge
+func (g *Gauge) Inc() {
+	g.c.add(1)
+}
+
+// Dec subtracts one from the gauge
+func (g *Gauge) Dec() {
+	g.c.add(-1)
+}
+
+// Observe counts value into the first bucket it fits in
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.c.mu.Lock()
+	defer h.c.mu.Unlock()
+	if h.c.counts == nil {
+		h.c.counts = make([]uint64, len(h.buckets)+1)
+	}
+	h.c.counts[i]++
+	h.c.sum += value
+	h.c.count++
+}
+
+func (f *family) child(values []string) *child {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.children[key]
+	if !ok {
+		c = &child{values: append([]string(nil), values...)}
+		f.children[key] = c
+	}
+	return c
+}
+
+func (c *child) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&c.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&c.bits, old, updated) {
+			return
+		}
+	}
+}
+
+/**
+ * WriteText
+ * Writes every metric in the Prometheus text exposition format. Families appear in the
+ * order they were registered and children sorted by their label values.
+ */
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, f := range families {
+		f.writeText(&buf)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+/**
+ * ServeHTTP
+ * Serves the metrics to a scraper.
+ */
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+func (f *family) writeText(buf *bytes.Buffer) {
+	f.mu.Lock()
+	children := make([]*child, 0, len(f.children))
+	for _, c := range f.children {
+		children = append(children, c)
+	}
+	f.mu.Unlock()
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].values, "\xff") < strings.Join(children[j].values, "\xff")
+	})
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+
+	for _, c := range children {
+		if f.kind != "histogram" {
+			value := math.Float64frombits(atomic.LoadUint64(&c.bits))
+			fmt.Fprintf(buf, "%s%s %s\n", f.name, f.labelText(c.values, "", ""), formatValue(value))
+			continue
+		}
+
+		c.mu.Lock()
+		counts := append([]uint64(nil), c.counts...)
+		sum, count := c.sum, c.count
+		c.mu.Unlock()
+		if counts == nil {
+			counts = make([]uint64, len(f.buckets)+1)
+		}
+
+		cumulative := uint64(0)
+		for i, bound := range f.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, f.labelText(c.values, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", f.name, f.labelText(c.values, "le", "+Inf"), count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", f.name, f.labelText(c.values, "", ""), formatValue(sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", f.name, f.labelText(c.values, "", ""), count)
+	}
+}
+
+// labelText renders the labels of a child, with an extra label appended when extraName is set
+func (f *family) labelText(values []string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, name := range f.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Error scraping: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the text exposition content type, Got: %q", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading scrape: %v", err)
+	}
+	return string(body)
+}
+
+func TestScrape(t *testing.T) {
+	r := NewRegistry()
+	active := r.Gauge("conns_active", "Open connections.")
+	bytes := r.Counter("received_bytes_total", "Bytes received.", "identity")
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+
+	active.With().Inc()
+	active.With().Inc()
+	active.With().Dec()
+	bytes.With("CN=b").Add(10)
+	bytes.With("CN=a").Add(2.5)
+	bytes.With("CN=a").Add(-1)
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(3)
+
+	expected := `# HELP conns_active Open connections.
+# TYPE conns_active gauge
+conns_active 1
+# HELP received_bytes_total Bytes received.
+# TYPE received_bytes_total counter
+received_bytes_total{identity="CN=a"} 2.5
+received_bytes_total{identity="CN=b"} 10
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.55
+latency_seconds_count 3
+`
+	if got := scrape(t, r); got != expected {
+		t.Errorf("Unexpected scrape, Expected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("odd_total", "Odd labels.", "name").With("a \"quoted\"\\name\n").Inc()
+
+	if got := scrape(t, r); !strings.Contains(got, `odd_total{name="a \"quoted\"\\name\n"} 1`) {
+		t.Errorf("Expected the label to be escaped, Got:\n%s", got)
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("twice_total", "")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering the same name twice to panic")
+		}
+	}()
+	r.Gauge("twice_total", "")
+}
@@ -0,0 +1,250 @@
+/**
+ * pubsub
+ * This package fans messages published to a topic out to every subscriber of that topic.
+ * Each subscription has a bounded queue so one slow subscriber can't hold up the
+ * publisher or the other subscribers. What happens when a queue is full is decided by
+ * the broker's Policy: either the oldest queued message is dropped to make room and the
+ * subscriber is told how many it missed, or the subscriber is disconnected.
+ */
+package pubsub
+
+import (
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Policy decides what happens to a subscriber whose queue is full
+type Policy int
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new one
+	DropOldest Policy = iota
+	// Disconnect ends the subscription, the subscriber has to subscribe again
+	Disconnect
+)
+
+// DefaultQueueSize is the number of messages queued per subscriber when none is configured
+const DefaultQueueSize = 256
+
+// maxTopicLength keeps topics well within a frame header
+const maxTopicLength = 256
+
+/**
+ * ParsePolicy
+ * Parses the name of a Policy, drop or disconnect.
+ */
+func ParsePolicy(name string) (Policy, error) {
+	switch name {
+	case "drop":
+		return DropOldest, nil
+	case "disconnect":
+		return Disconnect, nil
+	default:
+		return 0, fmt.Errorf("unknown slow subscriber policy %q, expected drop or disconnect", name)
+	}
+}
+
+func (p Policy) String() string {
+	if p == Disconnect {
+		return "disconnect"
+	}
+	return "drop"
+}
+
+/**
+ * ValidTopic
+ * Checks that topic is usable as a topic name, names are matched against access list
+ * patterns so they may not contain whitespace or pattern characters themselves.
+ */
+func ValidTopic(topic string) error {
+	if topic == "" || len(topic) > maxTopicLength || strings.ContainsAny(topic, `*?[]\`) ||
+		strings.IndexFunc(topic, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	return nil
+}
+
+// Broker routes published messages to the subscribers of their topic
+type Broker struct {
+	queueSize int
+	policy    Policy
+
+	mu     sync.RWMutex
+	topics map[string]map[*Subscription]bool
+}
+
+// Subscription receives the messages published to one topic
+type Subscription struct {
+	Topic    string
+	Identity string
+
+	broker *Broker
+	queue  chan *protocol.Frame
+
+	mu        sync.Mutex
+	dropped   int
+	kicked    chan struct{}
+	closeOnce sync.Once
+}
+
+/**
+ * New
+ * Creates a Broker queueing up to queueSize messages for every subscriber.
+ */
+func New(queueSize int, policy Policy) *Broker {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Broker{
+		queueSize: queueSize,
+		policy:    policy,
+		topics:    map[string]map[*Subscription]bool{},
+	}
+}
+
+/**
+ * Subscribe
+ * Starts queueing the messages published to topic for identity. The subscription must
+ * be closed once the subscriber goes away.
+ */
+func (b *Broker) Subscribe(topic string, identity string) *Subscription {
+	sub := &Subscription{
+		Topic:    topic,
+		Identity: identity,
+		broker:   b,
+		queue:    make(chan *protocol.Frame, b.queueSize),
+		kicked:   make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	if b.topics[topic] == nil {
+		b.topics[topic] = map[*Subscription]bool{}
+	}
+	b.topics[topic][sub] = true
+	b.mu.Unlock()
+	return sub
+}
+
+/**
+ * Publish
+ * Queues msg for every subscriber of topic and returns how many there were. The frame
+ * is shared between the subscribers and must not be modified afterwards.
+ */
+func (b *Broker) Publish(topic string, msg *protocol.Frame) int {
+	var slow []*Subscription
+
+	b.mu.RLock()
+	subs := b.topics[topic]
+	for sub := range subs {
+		if !sub.offer(msg, b.policy) {
+			slow = append(slow, sub)
+		}
+	}
+	count := len(subs)
+	b.mu.RUnlock()
+
+	for _, sub := range slow {
+		sub.kick()
+	}
+	return count
+}
+
+/**
+ * Subscribers
+ * Returns the number of subscribers of every topic which has any.
+ */
+func (b *Broker) Subscribers() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	counts := make(map[string]int, len(b.topics))
+	for topic, subs := range b.topics {
+		counts[topic] = len(subs)
+	}
+	return counts
+}
+
+/**
+ * C
+ * Returns the queue of messages waiting to be delivered to the subscriber.
+ */
+func (s *Subscription) C() <-chan *protocol.Frame {
+	return s.queue
+}
+
+/**
+ * Kicked
+ * Returns a channel which is closed when the broker ends the subscription because the
+ * subscriber fell too far behind.
+ */
+func (s *Subscription) Kicked() <-chan struct{} {
+	return s.kicked
+}
+
+/**
+ * TakeDropped
+ * Returns the number of messages dropped since it was last called.
+ */
+func (s *Subscription) TakeDropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+/**
+ * Close
+ * Ends the subscription, messages still queued are discarded.
+ */
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	if subs := s.broker.topics[s.Topic]; subs != nil {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(s.broker.topics, s.Topic)
+		}
+	}
+	s.broker.mu.Unlock()
+}
+
+// offer queues msg, applying policy when the queue is full. It reports false when the
+// subscriber has to be disconnected.
+func (s *Subscription) offer(msg *protocol.Frame, policy Policy) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case s.queue <- msg:
+		return true
+	default:
+	}
+
+	if policy == Disconnect {
+		return false
+	}
+
+	// the subscriber may have caught up in the meantime, in which case nothing is lost
+	select {
+	case <-s.queue:
+		s.dropped++
+	default:
+	}
+	select {
+	case s.queue <- msg:
+	default:
+		s.dropped++
+	}
+	return true
+}
+
+// kick ends the subscription on behalf of the broker
+func (s *Subscription) kick() {
+	s.Close()
+	s.closeOnce.Do(func() {
+		close(s.kicked)
+	})
+}
@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"github.com/mattsurabian/go-tls/shared/protocol"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	b := New(4, DropOldest)
+	first := b.Subscribe("builds", "CN=a")
+	second := b.Subscribe("builds", "CN=b")
+	other := b.Subscribe("alerts", "CN=c")
+
+	msg := protocol.NewMessage([]byte("done"))
+	if n := b.Publish("builds", msg); n != 2 {
+		t.Errorf("Expected 2 subscribers, Got: %d", n)
+	}
+	for _, sub := range []*Subscription{first, second} {
+		select {
+		case got := <-sub.C():
+			if string(got.Body) != "done" {
+				t.Errorf("Expected the published message, Got: %q", got.Body)
+			}
+		default:
+			t.Errorf("Expected %s to receive the message", sub.Identity)
+		}
+	}
+	if len(other.C()) != 0 {
+		t.Error("Expected other topics to receive nothing")
+	}
+
+	first.Close()
+	if n := b.Publish("builds", msg); n != 1 {
+		t.Errorf("Expected 1 subscriber after closing, Got: %d", n)
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	b := New(2, DropOldest)
+	sub := b.Subscribe("t", "CN=slow")
+
+	for _, body := range []string{"1", "2", "3", "4"} {
+		b.Publish("t", protocol.NewMessage([]byte(body)))
+	}
+
+	if n := sub.TakeDropped(); n != 2 {
+		t.Errorf("Expected 2 dropped messages, Got: %d", n)
+	}
+	if n := sub.TakeDropped(); n != 0 {
+		t.Errorf("Expected the dropped count to reset, Got: %d", n)
+	}
+	for _, want := range []string{"3", "4"} {
+		if got := <-sub.C(); string(got.Body) != want {
+			t.Errorf("Expected %s, Got: %s", want, got.Body)
+		}
+	}
+}
+
+func TestDisconnectSlowSubscriber(t *testing.T) {
+	b := New(1, Disconnect)
+	sub := b.Subscribe("t", "CN=slow")
+
+	b.Publish("t", protocol.NewMessage([]byte("1")))
+	select {
+	case <-sub.Kicked():
+		t.Fatal("Expected the subscriber to keep up with one message")
+	default:
+	}
+
+	b.Publish("t", protocol.NewMessage([]byte("2")))
+	select {
+	case <-sub.Kicked():
+	default:
+		t.Fatal("Expected the slow subscriber to be disconnected")
+	}
+	if n := b.Publish("t", protocol.NewMessage([]byte("3"))); n != 0 {
+		t.Errorf("Expected no subscribers left, Got: %d", n)
+	}
+}
+
+func TestValidTopic(t *testing.T) {
+	for _, topic := range []string{"builds", "sensors/floor-1/temp"} {
+		if err := ValidTopic(topic); err != nil {
+			t.Errorf("Expected %q to be valid, Got: %v", topic, err)
+		}
+	}
+	for _, topic := range []string{"", "a b", "sensors/*", "x?", "[a]"} {
+		if err := ValidTopic(topic); err == nil {
+			t.Errorf("Expected %q to be refused", topic)
+		}
+	}
+}
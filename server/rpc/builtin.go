@@ -0,0 +1,21 @@
+package rpc
+
+import (
+	"context"
+	"time"
+)
+
+// Echo answers every call with its own payload, handy to check the tunnel works
+var Echo = HandlerFunc(func(ctx context.Context, peer Peer, req Request) (Response, error) {
+	return Response{Payload: req.Payload}, nil
+})
+
+// Time answers with the server's current time in RFC 3339 format
+var Time = HandlerFunc(func(ctx context.Context, peer Peer, req Request) (Response, error) {
+	return Response{Payload: []byte(time.Now().Format(time.RFC3339Nano))}, nil
+})
+
+func init() {
+	Register("echo", Echo)
+	Register("time", Time)
+}
@@ -0,0 +1,151 @@
+/**
+ * rpc
+ * This package lets the server answer requests from clients. A Handler is registered
+ * under a method name and runs for every call of that method, receiving the identity
+ * of the calling client from its verified certificate.
+ *
+ * Programs embedding the server register their own handlers on DefaultRegistry before
+ * starting it:
+ *
+ *  rpc.Register("deploy", rpc.HandlerFunc(deploy))
+ *  os.Exit((&command.StartCommand{UI: ui}).Run(nil))
+ */
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var ErrUnknownMethod = errors.New("rpc: unknown method")
+
+// Peer identifies the client making a call
+type Peer struct {
+	// Identity is "CN=<common name>" from the client's verified certificate
+	Identity string
+	// Addr is the network address the client connected from
+	Addr string
+}
+
+// Request is a single call from a client
+type Request struct {
+	Method  string
+	Payload []byte
+}
+
+// Response is returned to the client when a handler succeeds
+type Response struct {
+	Payload []byte
+}
+
+// Handler answers calls of the method it is registered for. The context is canceled
+// when the client disconnects. Returned errors are reported to the client.
+type Handler interface {
+	Handle(ctx context.Context, peer Peer, req Request) (Response, error)
+}
+
+// HandlerFunc adapts an ordinary function to a Handler
+type HandlerFunc func(ctx context.Context, peer Peer, req Request) (Response, error)
+
+func (f HandlerFunc) Handle(ctx context.Context, peer Peer, req Request) (Response, error) {
+	return f(ctx, peer, req)
+}
+
+// Registry maps method names to handlers, it is safe for concurrent use
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+/**
+ * NewRegistry
+ * Creates an empty Registry.
+ */
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]Handler{}}
+}
+
+/**
+ * Register
+ * Registers h for method. Like http.Handle it panics when method is empty or already
+ * registered, as either is a programming error.
+ */
+func (r *Registry) Register(method string, h Handler) {
+	if method == "" || h == nil {
+		panic("rpc: Register needs a method name and a handler")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.handlers[method]; exists {
+		panic("rpc: method " + method + " registered twice")
+	}
+	r.handlers[method] = h
+}
+
+/**
+ * Clone
+ * Returns a new Registry holding the same handlers, methods registered on either one
+ * later aren't seen by the other.
+ */
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clone := NewRegistry()
+	for method, h := range r.handlers {
+		clone.handlers[method] = h
+	}
+	return clone
+}
+
+/**
+ * Methods
+ * Returns the registered method names in alphabetical order.
+ */
+func (r *Registry) Methods() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	methods := make([]string, 0, len(r.handlers))
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+/**
+ * Serve
+ * Runs the handler registered for req.Method. A panicking handler is turned into an
+ * error so one bad call can't take the server down.
+ */
+func (r *Registry) Serve(ctx context.Context, peer Peer, req Request) (resp Response, err error) {
+	r.mu.RLock()
+	h, ok := r.handlers[req.Method]
+	r.mu.RUnlock()
+	if !ok {
+		return Response{}, fmt.Errorf("%v %q", ErrUnknownMethod, req.Method)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			resp, err = Response{}, fmt.Errorf("rpc: %s handler panicked: %v", req.Method, p)
+		}
+	}()
+	return h.Handle(ctx, peer, req)
+}
+
+// DefaultRegistry is used by the server unless it is given another Registry
+var DefaultRegistry = NewRegistry()
+
+/**
+ * Register
+ * Registers h for method on DefaultRegistry.
+ */
+func Register(method string, h Handler) {
+	DefaultRegistry.Register(method, h)
+}
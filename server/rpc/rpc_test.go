@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	r := NewRegistry()
+	r.Register("whoami", HandlerFunc(func(ctx context.Context, peer Peer, req Request) (Response, error) {
+		return Response{Payload: []byte(peer.Identity)}, nil
+	}))
+	r.Register("fail", HandlerFunc(func(ctx context.Context, peer Peer, req Request) (Response, error) {
+		return Response{}, errors.New("nope")
+	}))
+	r.Register("panic", HandlerFunc(func(ctx context.Context, peer Peer, req Request) (Response, error) {
+		panic("boom")
+	}))
+
+	peer := Peer{Identity: "CN=Client0"}
+
+	resp, err := r.Serve(context.Background(), peer, Request{Method: "whoami"})
+	if err != nil || string(resp.Payload) != "CN=Client0" {
+		t.Errorf("Expected the peer identity, Got: %q, %v", resp.Payload, err)
+	}
+	if _, err := r.Serve(context.Background(), peer, Request{Method: "fail"}); err == nil || err.Error() != "nope" {
+		t.Errorf("Expected the handler error, Got: %v", err)
+	}
+	if _, err := r.Serve(context.Background(), peer, Request{Method: "panic"}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected a panic to become an error, Got: %v", err)
+	}
+	if _, err := r.Serve(context.Background(), peer, Request{Method: "missing"}); err == nil || !strings.Contains(err.Error(), "unknown method") {
+		t.Errorf("Expected an unknown method error, Got: %v", err)
+	}
+
+	if methods := r.Methods(); strings.Join(methods, ",") != "fail,panic,whoami" {
+		t.Errorf("Unexpected methods: %v", methods)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	resp, err := DefaultRegistry.Serve(context.Background(), Peer{}, Request{Method: "echo", Payload: []byte("hi")})
+	if err != nil || string(resp.Payload) != "hi" {
+		t.Errorf("Echo error! Expected: hi, Got: %q, %v", resp.Payload, err)
+	}
+	if _, err := DefaultRegistry.Serve(context.Background(), Peer{}, Request{Method: "time"}); err != nil {
+		t.Errorf("Error calling time: %v", err)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a method twice to panic")
+		}
+	}()
+	r := NewRegistry()
+	r.Register("echo", Echo)
+	r.Register("echo", Echo)
+}
+
+func TestClone(t *testing.T) {
+	r := NewRegistry()
+	noop := HandlerFunc(func(ctx context.Context, peer Peer, req Request) (Response, error) {
+		return Response{}, nil
+	})
+	r.Register("shared", noop)
+
+	clone := r.Clone()
+	clone.Register("exec.deploy", noop)
+	r.Register("later", noop)
+
+	if methods := strings.Join(clone.Methods(), ","); methods != "exec.deploy,shared" {
+		t.Errorf("Unexpected methods on the clone: %s", methods)
+	}
+	if methods := strings.Join(r.Methods(), ","); methods != "later,shared" {
+		t.Errorf("Expected the original to be left alone, Got: %s", methods)
+	}
+	// registering again on another clone doesn't collide with the first
+	r.Clone().Register("exec.deploy", noop)
+}
@@ -0,0 +1,86 @@
+/**
+ * sections
+ * This package reads the files of named sections that declare listeners, SNI
+ * certificates and exec actions:
+ *
+ *  # comments start with # or ;
+ *  [name]
+ *  key = value
+ *
+ * Section names must be unique and can't hold whitespace, and every key belongs to the
+ * section above it. What the keys mean is up to the caller, so each Entry keeps its line
+ * number for the caller's errors.
+ */
+package sections
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Section is one [name] of a file with its entries in the order they were written
+type Section struct {
+	Name    string
+	Entries []Entry
+}
+
+// Entry is one "key = value" line
+type Entry struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+/**
+ * Read
+ * Reads the sections of the file at filePath. Kind names what a section declares, e.g.
+ * listener, in the errors about section names.
+ */
+func Read(filePath string, kind string) ([]*Section, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sections []*Section
+	var current *Section
+	names := map[string]bool{}
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" || strings.ContainsAny(name, " \t") || names[name] {
+				return nil, fmt.Errorf("%s:%d: invalid or duplicate %s name %q", filePath, lineNumber, kind, name)
+			}
+			names[name] = true
+			current = &Section{Name: name}
+			sections = append(sections, current)
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if current == nil || eq < 0 {
+			return nil, fmt.Errorf("%s:%d: expected [%s name] or \"key = value\"", filePath, lineNumber, kind)
+		}
+		current.Entries = append(current.Entries, Entry{
+			Key:   strings.TrimSpace(line[:eq]),
+			Value: strings.TrimSpace(line[eq+1:]),
+			Line:  lineNumber,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
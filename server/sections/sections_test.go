@@ -0,0 +1,48 @@
+package sections
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "test.conf")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRead(t *testing.T) {
+	path := writeFile(t, "# comment\n[first]\nkey = value = more\n; comment\n\n[second]\nempty =\n")
+
+	sections, err := Read(path, "test")
+	if err != nil {
+		t.Fatalf("Error reading sections: %v", err)
+	}
+	if len(sections) != 2 || sections[0].Name != "first" || sections[1].Name != "second" {
+		t.Fatalf("Unexpected sections: %+v", sections)
+	}
+	if e := sections[0].Entries; len(e) != 1 || e[0] != (Entry{Key: "key", Value: "value = more", Line: 3}) {
+		t.Errorf("Unexpected entries: %+v", e)
+	}
+	if e := sections[1].Entries; len(e) != 1 || e[0] != (Entry{Key: "empty", Value: "", Line: 7}) {
+		t.Errorf("Unexpected entries: %+v", e)
+	}
+}
+
+func TestInvalidFiles(t *testing.T) {
+	cases := map[string]string{
+		"key outside a section": "key = value\n",
+		"missing equals":        "[a]\nkey value\n",
+		"duplicate name":        "[a]\n[a]\n",
+		"empty name":            "[ ]\n",
+		"name with whitespace":  "[a b]\n",
+	}
+	for name, content := range cases {
+		if _, err := Read(writeFile(t, content), "test"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
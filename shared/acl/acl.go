@@ -0,0 +1,145 @@
+/**
+ * acl
+ * This package loads access lists which map a client certificate identity to the
+ * resources it may use, such as forwarding targets. Each non-empty line of an access
+ * list file holds an identity, a colon and a whitespace separated list of patterns:
+ *
+ *  # identity      allowed resources
+ *  CN=Client0:     db.internal:5432 127.0.0.1:*
+ *  *:              metrics:9100
+ *
+ * Identities are matched exactly, the special identity * applies to every client.
+ * Patterns use the syntax of path.Match so a * matches any run of characters.
+ */
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// AnyIdentity is the identity whose rules apply to every client
+const AnyIdentity = "*"
+
+// List maps identities to the patterns they are allowed to use
+type List struct {
+	rules map[string][]string
+}
+
+/**
+ * New
+ * Returns an empty List which denies everything until rules are added.
+ */
+func New() *List {
+	return &List{rules: map[string][]string{}}
+}
+
+/**
+ * Load
+ * Reads an access list file. An empty path yields an empty List, which denies everything.
+ */
+func Load(filePath string) (*List, error) {
+	list := New()
+	if filePath == "" {
+		return list, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		identity, patterns, ok := splitRule(line)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected \"identity: pattern...\"", filePath, lineNumber)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid pattern %q", filePath, lineNumber, pattern)
+			}
+		}
+		list.Add(identity, patterns...)
+	}
+
+	return list, scanner.Err()
+}
+
+/**
+ * Add
+ * Allows identity to use every resource matching one of the patterns.
+ */
+func (l *List) Add(identity string, patterns ...string) {
+	l.rules[identity] = append(l.rules[identity], patterns...)
+}
+
+/**
+ * Allowed
+ * Reports whether identity may use resource, either through its own rules or the
+ * rules for AnyIdentity.
+ */
+func (l *List) Allowed(identity string, resource string) bool {
+	if l == nil {
+		return false
+	}
+	return matchAny(l.rules[identity], resource) || matchAny(l.rules[AnyIdentity], resource)
+}
+
+/**
+ * Names
+ * Reports whether identity has rules of its own, rules for AnyIdentity don't count.
+ */
+func (l *List) Names(identity string) bool {
+	if l == nil || identity == AnyIdentity {
+		return false
+	}
+	_, ok := l.rules[identity]
+	return ok
+}
+
+/**
+ * Empty
+ * Reports whether the list holds no rules at all.
+ */
+func (l *List) Empty() bool {
+	return l == nil || len(l.rules) == 0
+}
+
+func matchAny(patterns []string, resource string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, resource); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRule separates the identity from its patterns, the identity ends at the first
+// colon followed by whitespace (or the end of the line) so host:port patterns survive
+func splitRule(line string) (string, []string, bool) {
+	for i := 0; i < len(line); i++ {
+		if line[i] != ':' {
+			continue
+		}
+		if i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t' {
+			identity := strings.TrimSpace(line[:i])
+			if identity == "" {
+				return "", nil, false
+			}
+			return identity, strings.Fields(line[i+1:]), true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,66 @@
+package acl
+
+import (
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	list, err := Load("./testdata/forward.acl")
+	if err != nil {
+		t.Fatalf("Error loading access list: %v", err)
+	}
+
+	cases := []struct {
+		identity string
+		resource string
+		expected bool
+	}{
+		{"CN=Client0", "db.internal:5432", true},
+		{"CN=Client0", "127.0.0.1:22", true},
+		{"CN=Client0", "db.internal:5433", false},
+		{"CN=GoTLS CA", "ca.internal:443", true},
+		{"CN=Client1", "db.internal:5432", false},
+		{"CN=Client1", "metrics:9100", true},
+		{"CN=Empty", "db.internal:5432", false},
+		{"", "metrics:9100", true},
+	}
+	for _, c := range cases {
+		if got := list.Allowed(c.identity, c.resource); got != c.expected {
+			t.Errorf("Access list error! Identity: %s, Resource: %s, Expected: %t, Got: %t", c.identity, c.resource, c.expected, got)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	list, err := Load("./testdata/forward.acl")
+	if err != nil {
+		t.Fatalf("Error loading access list: %v", err)
+	}
+
+	cases := map[string]bool{
+		"CN=Client0": true,
+		"CN=Empty":   true,
+		"CN=Client1": false,
+		AnyIdentity:  false,
+	}
+	for identity, expected := range cases {
+		if got := list.Names(identity); got != expected {
+			t.Errorf("Access list error! Identity: %s, Expected: %t, Got: %t", identity, expected, got)
+		}
+	}
+}
+
+func TestEmptyListDenies(t *testing.T) {
+	list, err := Load("")
+	if err != nil {
+		t.Fatalf("Error loading access list: %v", err)
+	}
+	if !list.Empty() || list.Allowed("CN=Client0", "db.internal:5432") {
+		t.Errorf("Expected an empty list to deny everything")
+	}
+
+	var nilList *List
+	if nilList.Allowed("CN=Client0", "db.internal:5432") {
+		t.Errorf("Expected a nil list to deny everything")
+	}
+}
@@ -31,13 +31,17 @@ package cliUtils
 
 import (
 	"flag"
+	"github.com/mattsurabian/go-tls/shared/units"
 	"github.com/mitchellh/cli"
 	"github.com/rakyll/globalconf"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"testing"
+	"time"
 )
 
 // In the event the config flag isn't passed this is the filename that will be searched for
@@ -57,6 +61,40 @@ var serverTLSKey string
 var clientTLSCert string
 var clientTLSKey string
 var rootName string
+var forwardAllow string
+var exposeAllow string
+var heartbeatInterval string
+var heartbeatMisses string
+var idleTimeout string
+var spoolDir string
+var spoolMaxSize string
+var agentSocket string
+var execActions string
+var filesDir string
+var maxUploadSize string
+var partialMaxAge string
+var publishAllow string
+var subscribeAllow string
+var subscriberQueue string
+var slowSubscriber string
+var directAllow string
+var offlineQueue string
+var metricsListen string
+var logFormat string
+var logLevel string
+var logPayloads string
+var auditLog string
+var adminSocket string
+var healthListen string
+var healthTLS string
+var listeners string
+var sniCerts string
+var sniUnknown string
+var serverName string
+var clientCA string
+var serverCA string
+var certChain string
+var certChainTrimRoot string
 
 // Globalconf is used to intelligently merge flags and INI config values as well as
 // persist changes to disk
@@ -66,6 +104,9 @@ var ui = &cli.BasicUi{
 	Reader: os.Stdin,
 }
 
+// SystemCAs stands for the operating system's trusted CAs in a list of CA paths
+const SystemCAs = "system"
+
 //////////////// PUBLIC GETTERS /////////////////////
 
 func GetConfigFilePath() string {
@@ -75,6 +116,43 @@ func GetRootCert() string {
 	return rootCert
 }
 
+// GetClientCAs returns the CAs trusted to sign client certificates, root-cert unless
+// client-ca is set. The system's CAs in root-cert are left out, they are there to verify
+// servers and would let in anyone holding a publicly trusted certificate.
+func GetClientCAs() []string {
+	if clientCA == "" {
+		var paths []string
+		for _, path := range splitPathList(rootCert) {
+			if path != SystemCAs {
+				paths = append(paths, path)
+			}
+		}
+		return paths
+	}
+	return splitPathList(clientCA)
+}
+
+func GetCertChainPath() string {
+	return certChain
+}
+
+func GetCertChainTrimRoot() bool {
+	return certChainTrimRoot == "true"
+}
+
+// GetServerCAs returns the CAs trusted to sign server certificates, root-cert unless
+// server-ca is set
+func GetServerCAs() []string {
+	if serverCA == "" {
+		return splitPathList(rootCert)
+	}
+	return splitPathList(serverCA)
+}
+
+func GetHost() string {
+	return host
+}
+
 func GetHostAndPort() string {
 	return host + ":" + port
 }
@@ -99,6 +177,196 @@ func GetRootName() string {
 	return rootName
 }
 
+// GetServerName falls back to the host the client dials
+func GetServerName() string {
+	if serverName == "" {
+		return host
+	}
+	return serverName
+}
+
+func GetForwardAllowPath() string {
+	return forwardAllow
+}
+
+func GetExposeAllowPath() string {
+	return exposeAllow
+}
+
+func GetExecActionsPath() string {
+	return execActions
+}
+
+func GetFilesDir() string {
+	return filesDir
+}
+
+// GetMaxUploadSize returns the largest file a client may push in bytes, the option accepts a K, M or G suffix
+func GetMaxUploadSize() int64 {
+	size, err := units.ParseSize(maxUploadSize)
+	if err != nil {
+		log.Printf("invalid max-upload-size %q, using 1GB\n", maxUploadSize)
+		return 1 << 30
+	}
+	return size
+}
+
+// GetPartialMaxAge returns zero, keeping interrupted uploads forever, when the option isn't a valid duration
+func GetPartialMaxAge() time.Duration {
+	age, err := time.ParseDuration(partialMaxAge)
+	if err != nil || age < 0 {
+		log.Printf("invalid partial-max-age %q, interrupted uploads are kept\n", partialMaxAge)
+		return 0
+	}
+	return age
+}
+
+func GetPublishAllowPath() string {
+	return publishAllow
+}
+
+func GetSubscribeAllowPath() string {
+	return subscribeAllow
+}
+
+// GetSubscriberQueue returns zero, leaving the choice to the server, when the option isn't a number
+func GetSubscriberQueue() int {
+	size, err := strconv.Atoi(subscriberQueue)
+	if err != nil || size < 1 {
+		return 0
+	}
+	return size
+}
+
+func GetSlowSubscriberPolicy() string {
+	return slowSubscriber
+}
+
+func GetHealthListen() string {
+	return healthListen
+}
+
+func GetHealthTLS() string {
+	return healthTLS
+}
+
+func GetListenersPath() string {
+	return listeners
+}
+
+func GetSNICertsPath() string {
+	return sniCerts
+}
+
+func GetSNIUnknown() string {
+	return sniUnknown
+}
+
+func GetMetricsListen() string {
+	return metricsListen
+}
+
+func GetLogFormat() string {
+	return logFormat
+}
+
+func GetLogLevel() string {
+	return logLevel
+}
+
+func GetLogPayloads() string {
+	return logPayloads
+}
+
+func GetAuditLogPath() string {
+	return auditLog
+}
+
+func GetDirectAllowPath() string {
+	return directAllow
+}
+
+// GetOfflineQueue returns zero, refusing messages for offline clients, when the option isn't a number
+func GetOfflineQueue() int {
+	size, err := strconv.Atoi(offlineQueue)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// GetHeartbeatInterval returns zero, disabling heartbeats, when the option isn't a valid duration
+func GetHeartbeatInterval() time.Duration {
+	interval, err := time.ParseDuration(heartbeatInterval)
+	if err != nil {
+		log.Printf("invalid heartbeat-interval %q, heartbeats disabled\n", heartbeatInterval)
+		return 0
+	}
+	return interval
+}
+
+// GetIdleTimeout returns zero, never closing quiet connections, when the option isn't a valid duration
+func GetIdleTimeout() time.Duration {
+	timeout, err := time.ParseDuration(idleTimeout)
+	if err != nil || timeout < 0 {
+		log.Printf("invalid idle-timeout %q, quiet connections are kept open\n", idleTimeout)
+		return 0
+	}
+	return timeout
+}
+
+func GetHeartbeatMisses() int {
+	misses, err := strconv.Atoi(heartbeatMisses)
+	if err != nil || misses < 1 {
+		return 3
+	}
+	return misses
+}
+
+// GetAgentSocketPath defaults to a socket in the user's home directory
+func GetAgentSocketPath() string {
+	if agentSocket == "" {
+		return filepath.Join(userHomeDir, ".go-tls-agent.sock")
+	}
+	return agentSocket
+}
+
+// GetAdminSocketPath defaults to a socket in the user's home directory
+func GetAdminSocketPath() string {
+	if adminSocket == "" {
+		return filepath.Join(userHomeDir, ".go-tls-admin.sock")
+	}
+	return adminSocket
+}
+
+/**
+ * ServerConfig
+ * Returns the current value of every option used by the server, by name.
+ */
+func ServerConfig() map[string]string {
+	values := map[string]string{"config": configFilePath}
+	flag.VisitAll(func(f *flag.Flag) {
+		if isServerConfigFlag(f.Name) {
+			values[f.Name] = f.Value.String()
+		}
+	})
+	return values
+}
+
+func GetSpoolDir() string {
+	return spoolDir
+}
+
+// GetSpoolMaxSize returns the spool limit in bytes, the option accepts a K, M or G suffix
+func GetSpoolMaxSize() int64 {
+	size, err := units.ParseSize(spoolMaxSize)
+	if err != nil {
+		log.Printf("invalid spool-max-size %q, using 64MB\n", spoolMaxSize)
+		return 64 << 20
+	}
+	return size
+}
+
 /**
  * init
  * Initialize flags and set helper variables like currentWorkingDirectory and userHomeDir.
@@ -106,14 +374,48 @@ func GetRootName() string {
  */
 func init() {
 	flag.StringVar(&configFilePath, "config", "", "What is the path to the configurationManager file?")
-	flag.StringVar(&rootCert, "root-cert", "", "What is the path to the root CA certificate for TLS?")
-	flag.StringVar(&rootName, "root-name", "", "What is the name on the CA cert?")
+	flag.StringVar(&rootCert, "root-cert", "", "What are the paths to the root CA certificates or directories of them for TLS? (comma separated, system for the system's CAs)")
+	flag.StringVar(&clientCA, "client-ca", "", "What are the paths to the CAs trusted to sign client certificates? (defaults to root-cert)")
+	flag.StringVar(&certChain, "cert-chain", "", "What is the path to the intermediate certificates to present after our TLS certificate?")
+	flag.StringVar(&certChainTrimRoot, "cert-chain-trim-root", "false", "Should a root CA at the end of the certificate chain be left out of handshakes? (true/false)")
+	flag.StringVar(&serverCA, "server-ca", "", "What are the paths to the CAs trusted to sign the server's certificate? (defaults to root-cert)")
+	flag.StringVar(&rootName, "root-name", "", "What is the name on the CA cert the server's certificate must be issued by?")
 	flag.StringVar(&host, "host", "", "What is the domain name or ip address of the server?")
 	flag.StringVar(&port, "port", "", "What port should the server be listening on?")
 	flag.StringVar(&serverTLSCert, "server-tls-cert", "", "What is the path to the server's TLS certificate?")
 	flag.StringVar(&serverTLSKey, "server-tls-key", "", "What is the path to the server's TLS key?")
 	flag.StringVar(&clientTLSCert, "client-tls-cert", "", "What is the path to the TLS client certificate?")
 	flag.StringVar(&clientTLSKey, "client-tls-key", "", "What is the path to the TLS client key?")
+	flag.StringVar(&forwardAllow, "forward-allow", "", "What is the path to the list of forwarding targets each client may reach?")
+	flag.StringVar(&exposeAllow, "expose-allow", "", "What is the path to the list of ports each client may expose?")
+	flag.StringVar(&execActions, "exec-actions", "", "What is the path to the file of commands clients may run?")
+	flag.StringVar(&filesDir, "files-dir", "", "What is the path to the directory files are pushed to and pulled from?")
+	flag.StringVar(&maxUploadSize, "max-upload-size", "1GB", "How large may a file pushed to files-dir be?")
+	flag.StringVar(&partialMaxAge, "partial-max-age", "24h", "How long may an interrupted push wait to be resumed before it is deleted? (0 keeps it)")
+	flag.StringVar(&publishAllow, "publish-allow", "", "What is the path to the list of topics each client may publish to?")
+	flag.StringVar(&subscribeAllow, "subscribe-allow", "", "What is the path to the list of topics each client may subscribe to?")
+	flag.StringVar(&subscriberQueue, "subscriber-queue", "256", "How many messages may be queued for a subscriber which is falling behind?")
+	flag.StringVar(&slowSubscriber, "slow-subscriber", "drop", "Should a subscriber with a full queue lose its oldest messages or be disconnected? (drop/disconnect)")
+	flag.StringVar(&directAllow, "direct-allow", "", "What is the path to the list of clients each client may message directly?")
+	flag.StringVar(&offlineQueue, "offline-queue", "0", "How many direct messages may be held for a client which isn't connected? (0 refuses them)")
+	flag.StringVar(&metricsListen, "metrics-listen", "", "What address should metrics be served on over plain HTTP? (e.g. 127.0.0.1:9100)")
+	flag.StringVar(&adminSocket, "admin-socket", "", "What is the path to the server's admin Unix socket?")
+	flag.StringVar(&auditLog, "audit-log", "", "What is the path to the tamper-evident audit log?")
+	flag.StringVar(&logFormat, "log-format", "text", "Should logs be written as text or as JSON? (text/json)")
+	flag.StringVar(&logLevel, "log-level", "info", "What is the lowest level of log messages to write? (debug/info/warn/error)")
+	flag.StringVar(&logPayloads, "log-payloads", "full", "Should message bodies be logged in full, redacted to a size and checksum, or not at all? (full/redacted/off)")
+	flag.StringVar(&healthListen, "health-listen", "", "What address should the health endpoints be served on? (e.g. 127.0.0.1:8080)")
+	flag.StringVar(&healthTLS, "health-tls", "plain", "Should the health endpoints be served over plain HTTP or require mutual TLS? (plain/mtls)")
+	flag.StringVar(&listeners, "listeners", "", "What is the path to the file declaring several listeners? (replaces host and port)")
+	flag.StringVar(&sniCerts, "sni-certs", "", "What is the path to the file of certificates to choose from by the name clients ask for?")
+	flag.StringVar(&sniUnknown, "sni-unknown", "default", "Should clients asking for a name without a certificate get the default one or be rejected? (default/reject)")
+	flag.StringVar(&serverName, "server-name", "", "What name should be asked of the server and verified on its certificate? (defaults to host)")
+	flag.StringVar(&heartbeatInterval, "heartbeat-interval", "30s", "How often should long-lived connections be pinged? (0 disables)")
+	flag.StringVar(&heartbeatMisses, "heartbeat-misses", "3", "How many pings in a row may go unanswered before a connection is closed?")
+	flag.StringVar(&idleTimeout, "idle-timeout", "10m", "How long may a connection without heartbeats go without traffic before it is closed? (0 disables)")
+	flag.StringVar(&spoolDir, "spool-dir", "", "What is the path to the directory holding messages which couldn't be delivered?")
+	flag.StringVar(&spoolMaxSize, "spool-max-size", "64MB", "How much data may be held in the spool directory?")
+	flag.StringVar(&agentSocket, "agent-socket", "", "What is the path to the client agent's Unix socket?")
 
 	var err error
 	currentWorkingDirectory, err = os.Getwd()
@@ -124,6 +426,11 @@ func init() {
 	usr, _ := user.Current()
 	userHomeDir = usr.HomeDir
 
+	// test binaries parse their own flags, and the options keep their defaults there
+	if testing.Testing() {
+		return
+	}
+
 	// If any flags containing a file path were passed in
 	// on the command line we want to resolve them to absolute paths
 	// relative to the current working directory. We'll make this call
@@ -248,7 +555,9 @@ func getAbsPath(path string, base string) string {
  */
 func flagStoresPathString(flagName string) bool {
 	switch flagName {
-	case "host", "port", "root-name":
+	case "host", "port", "root-name", "server-name", "sni-unknown", "heartbeat-interval", "heartbeat-misses", "spool-max-size",
+		"idle-timeout", "max-upload-size", "partial-max-age", "subscriber-queue", "slow-subscriber", "offline-queue", "cert-chain-trim-root",
+		"metrics-listen", "log-format", "log-level", "log-payloads", "health-listen", "health-tls":
 		return false
 	default:
 		return true
@@ -262,8 +571,48 @@ func flagStoresPathString(flagName string) bool {
  */
 func resolveAbsoluteFlagPaths(f *flag.Flag) {
 	if flagStoresPathString(f.Name) {
-		f.Value.Set(getAbsPath(f.Value.String(), flagBasePath))
+		f.Value.Set(resolveFlagPath(f.Name, f.Value.String(), flagBasePath))
+	}
+}
+
+/**
+ * resolveFlagPath
+ * Expands the path stored in a flag, or every path of the flags taking a list of them.
+ */
+func resolveFlagPath(flagName string, value string, base string) string {
+	if !flagStoresPathList(flagName) {
+		return getAbsPath(value, base)
+	}
+	paths := splitPathList(value)
+	for i, path := range paths {
+		if path != SystemCAs {
+			paths[i] = getAbsPath(path, base)
+		}
+	}
+	return strings.Join(paths, ",")
+}
+
+/**
+ * flagStoresPathList
+ * Helper method that returns true for flags holding a comma separated list of paths.
+ */
+func flagStoresPathList(flagName string) bool {
+	switch flagName {
+	case "root-cert", "client-ca", "server-ca":
+		return true
+	default:
+		return false
+	}
+}
+
+func splitPathList(value string) []string {
+	var paths []string
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
 	}
+	return paths
 }
 
 /**
@@ -380,7 +729,10 @@ func iterateOverClientConfigFlags(f *flag.Flag) {
  */
 func isClientConfigFlag(flagName string) bool {
 	switch flagName {
-	case "host", "port", "root-cert", "root-name", "client-tls-cert", "client-tls-key":
+	case "host", "port", "root-cert", "client-ca", "server-ca", "root-name", "server-name", "client-tls-cert", "client-tls-key",
+		"cert-chain", "cert-chain-trim-root",
+		"heartbeat-interval", "heartbeat-misses", "spool-dir", "spool-max-size", "agent-socket",
+		"log-format", "log-level", "log-payloads":
 		return true
 	default:
 		return false
@@ -404,7 +756,12 @@ func iterateOverServerConfigFlags(f *flag.Flag) {
  */
 func isServerConfigFlag(flagName string) bool {
 	switch flagName {
-	case "host", "port", "root-cert", "server-tls-cert", "server-tls-key":
+	case "host", "port", "root-cert", "client-ca", "server-ca", "server-tls-cert", "server-tls-key", "cert-chain",
+		"cert-chain-trim-root", "forward-allow", "expose-allow",
+		"exec-actions", "files-dir", "max-upload-size", "partial-max-age", "publish-allow", "subscribe-allow", "subscriber-queue", "slow-subscriber",
+		"direct-allow", "offline-queue", "metrics-listen", "heartbeat-interval", "heartbeat-misses", "idle-timeout",
+		"log-format", "log-level", "log-payloads", "audit-log", "admin-socket", "health-listen", "health-tls",
+		"listeners", "sni-certs", "sni-unknown":
 		return true
 	default:
 		return false
@@ -424,7 +781,7 @@ func promptForAndPersistFlagValue(f *flag.Flag) {
 	}
 	if response != "" {
 		if flagStoresPathString(f.Name) {
-			response = getAbsPath(response, currentWorkingDirectory)
+			response = resolveFlagPath(f.Name, response, currentWorkingDirectory)
 		}
 		f.Value.Set(response)
 		configurationManager.Set("", f)
@@ -0,0 +1,159 @@
+/**
+ * heartbeat
+ * This package detects dead peers on long-lived connections. A Monitor sends a ping at
+ * a fixed interval and expects a pong back, once too many pings in a row have gone
+ * unanswered the peer is considered dead and the connection is torn down. Every pong
+ * also yields a round trip time measurement.
+ */
+package heartbeat
+
+import (
+	"sync"
+	"time"
+)
+
+// Monitor pings a peer at a fixed interval and declares it dead after too many missed pongs
+type Monitor struct {
+	interval  time.Duration
+	maxMissed int
+	ping      func() error
+	onDead    func()
+
+	mu       sync.Mutex
+	missed   int
+	pongs    int
+	lastRTT  time.Duration
+	totalRTT time.Duration
+	observe  func(rtt time.Duration)
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Stats summarizes the round trip times measured by a Monitor
+type Stats struct {
+	Pongs   int
+	LastRTT time.Duration
+	AvgRTT  time.Duration
+}
+
+/**
+ * Start
+ * Creates a Monitor and starts pinging. ping must send a single ping to the peer and
+ * onDead is called once, from the Monitor's goroutine, when maxMissed pings in a row
+ * went unanswered. An interval of zero disables heartbeats and returns a nil Monitor,
+ * all methods are safe to call on a nil Monitor.
+ */
+func Start(interval time.Duration, maxMissed int, ping func() error, onDead func()) *Monitor {
+	if interval <= 0 {
+		return nil
+	}
+	if maxMissed < 1 {
+		maxMissed = 1
+	}
+
+	m := &Monitor{
+		interval:  interval,
+		maxMissed: maxMissed,
+		ping:      ping,
+		onDead:    onDead,
+		stop:      make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *Monitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		m.mu.Lock()
+		dead := m.missed >= m.maxMissed
+		m.missed++
+		m.mu.Unlock()
+
+		if dead {
+			m.Stop()
+			m.onDead()
+			return
+		}
+
+		if err := m.ping(); err != nil {
+			// the connection is already broken, whoever is reading from it will notice
+			m.Stop()
+			return
+		}
+	}
+}
+
+/**
+ * Pong
+ * Records an answer from the peer along with the round trip time it measured.
+ */
+func (m *Monitor) Pong(rtt time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.missed = 0
+	m.pongs++
+	m.lastRTT = rtt
+	m.totalRTT += rtt
+	observe := m.observe
+	m.mu.Unlock()
+
+	if observe != nil {
+		observe(rtt)
+	}
+}
+
+/**
+ * OnPong
+ * Calls observe with the round trip time of every pong recorded from now on.
+ */
+func (m *Monitor) OnPong(observe func(rtt time.Duration)) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observe = observe
+}
+
+/**
+ * Stats
+ * Returns the round trip times measured so far.
+ */
+func (m *Monitor) Stats() Stats {
+	if m == nil {
+		return Stats{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := Stats{Pongs: m.pongs, LastRTT: m.lastRTT}
+	if m.pongs > 0 {
+		stats.AvgRTT = m.totalRTT / time.Duration(m.pongs)
+	}
+	return stats
+}
+
+/**
+ * Stop
+ * Stops sending pings, it is safe to call more than once.
+ */
+func (m *Monitor) Stop() {
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
@@ -0,0 +1,71 @@
+package heartbeat
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeadPeerDetected(t *testing.T) {
+	var pings int32
+	dead := make(chan struct{})
+
+	m := Start(5*time.Millisecond, 3, func() error {
+		atomic.AddInt32(&pings, 1)
+		return nil
+	}, func() {
+		close(dead)
+	})
+	defer m.Stop()
+
+	select {
+	case <-dead:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the peer to be declared dead")
+	}
+
+	if n := atomic.LoadInt32(&pings); n != 3 {
+		t.Errorf("Expected 3 unanswered pings before giving up, Got: %d", n)
+	}
+}
+
+func TestPongKeepsPeerAlive(t *testing.T) {
+	dead := make(chan struct{})
+	pings := make(chan struct{}, 10)
+	m := Start(5*time.Millisecond, 2, func() error {
+		pings <- struct{}{}
+		return nil
+	}, func() {
+		close(dead)
+	})
+	go func() {
+		for range pings {
+			m.Pong(time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-dead:
+		t.Fatal("Expected an answering peer to stay alive")
+	case <-time.After(100 * time.Millisecond):
+	}
+	m.Stop()
+
+	stats := m.Stats()
+	if stats.Pongs == 0 || stats.LastRTT != time.Millisecond || stats.AvgRTT != time.Millisecond {
+		t.Errorf("Unexpected round trip stats: %+v", stats)
+	}
+}
+
+func TestZeroIntervalDisables(t *testing.T) {
+	m := Start(0, 3, nil, nil)
+	if m != nil {
+		t.Fatal("Expected a zero interval to disable heartbeats")
+	}
+	// methods on a disabled monitor are no-ops
+	m.Pong(time.Second)
+	m.Stop()
+	if m.Stats().Pongs != 0 {
+		t.Errorf("Expected no stats from a disabled monitor")
+	}
+}
@@ -0,0 +1,161 @@
+/**
+ * logging
+ * This package configures structured logging for both binaries on top of log/slog. Every
+ * record carries a level and key/value fields and is written either as logfmt style text
+ * or as one JSON object per line. Output of the standard log package is routed through
+ * the same handler at the info level, so nothing is written in a different format.
+ *
+ * Connection events use the same field names everywhere so log pipelines can index them:
+ * conn_id, remote_addr, client_cn, tls_version, cipher, bytes and duration.
+ */
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Field names shared by every connection event
+const (
+	ConnID     = "conn_id"
+	Listener   = "listener"
+	RemoteAddr = "remote_addr"
+	ClientCN   = "client_cn"
+	TLSVersion = "tls_version"
+	Cipher     = "cipher"
+	Bytes      = "bytes"
+	Duration   = "duration"
+)
+
+// PayloadMode decides how much of a message body may be logged
+type PayloadMode int
+
+const (
+	// PayloadFull logs message bodies as they are
+	PayloadFull PayloadMode = iota
+	// PayloadRedacted logs the size and a SHA-256 fingerprint of message bodies instead
+	PayloadRedacted
+	// PayloadOff logs nothing about message bodies but their size
+	PayloadOff
+)
+
+// level is shared by every logger so it can be changed while running
+var level = new(slog.LevelVar)
+
+/**
+ * Setup
+ * Installs the default logger writing to w in format, text or json, and discarding
+ * records below the named level.
+ */
+func Setup(w io.Writer, format string, levelName string) error {
+	lvl, err := ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+/**
+ * ParseLevel
+ * Parses a level name: debug, info, warn or error.
+ */
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+}
+
+/**
+ * SetLevel
+ * Changes the level of the default logger while it is running.
+ */
+func SetLevel(name string) error {
+	lvl, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(lvl)
+	return nil
+}
+
+/**
+ * Level
+ * Returns the name of the current level.
+ */
+func Level() string {
+	return strings.ToLower(level.Level().String())
+}
+
+/**
+ * Transfer
+ * Returns the fields counting the bytes crossing a connection, bytes holds the total so
+ * it means the same in every event.
+ */
+func Transfer(received int64, sent int64) slog.Attr {
+	// a group without a key is inlined into the record
+	return slog.Group("",
+		slog.Int64(Bytes, received+sent),
+		slog.Int64(Bytes+"_received", received),
+		slog.Int64(Bytes+"_sent", sent),
+	)
+}
+
+/**
+ * ParsePayloadMode
+ * Parses a payload mode name: full, redacted or off.
+ */
+func ParsePayloadMode(name string) (PayloadMode, error) {
+	switch name {
+	case "", "full":
+		return PayloadFull, nil
+	case "redacted":
+		return PayloadRedacted, nil
+	case "off":
+		return PayloadOff, nil
+	default:
+		return 0, fmt.Errorf("unknown payload logging mode %q, expected full, redacted or off", name)
+	}
+}
+
+/**
+ * Payload
+ * Returns the fields describing a message body in the given mode.
+ */
+func Payload(mode PayloadMode, body []byte) []any {
+	attrs := []any{slog.Int(Bytes, len(body))}
+	switch mode {
+	case PayloadFull:
+		attrs = append(attrs, slog.String("payload", string(body)))
+	case PayloadRedacted:
+		sum := sha256.Sum256(body)
+		attrs = append(attrs, slog.String("payload_sha256", hex.EncodeToString(sum[:])))
+	}
+	return attrs
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestJSONFieldsAndLevels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Setup(&buf, "json", "info"); err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Debug("hidden")
+	slog.Info("connection open", ConnID, "1a2b", ClientCN, "CN=Client0")
+	log.Println("from the log package")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records above the debug level, Got:\n%s", buf.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected JSON, Got: %s", lines[0])
+	}
+	if record["level"] != "INFO" || record["msg"] != "connection open" || record[ClientCN] != "CN=Client0" {
+		t.Errorf("Unexpected record: %v", record)
+	}
+	if !strings.Contains(lines[1], `"msg":"from the log package"`) {
+		t.Errorf("Expected the log package to be routed through the handler, Got: %s", lines[1])
+	}
+
+	buf.Reset()
+	SetLevel("debug")
+	slog.Debug("shown")
+	if !strings.Contains(buf.String(), "shown") || Level() != "debug" {
+		t.Errorf("Expected debug records after changing the level, Got: %q", buf.String())
+	}
+}
+
+func TestPayloadModes(t *testing.T) {
+	var buf bytes.Buffer
+	Setup(&buf, "text", "info")
+
+	body := []byte("password=hunter2")
+	for _, mode := range []PayloadMode{PayloadFull, PayloadRedacted, PayloadOff} {
+		slog.Info("received", Payload(mode, body)...)
+	}
+
+	slog.Info("connection closed", Transfer(10, 5))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !strings.Contains(lines[3], "bytes=15 bytes_received=10 bytes_sent=5") {
+		t.Errorf("Expected the transfer fields inlined, Got: %s", lines[3])
+	}
+	lines = lines[:3]
+	if !strings.Contains(lines[0], "hunter2") {
+		t.Errorf("Expected the full payload, Got: %s", lines[0])
+	}
+	for _, line := range lines[1:] {
+		if strings.Contains(line, "hunter2") || !strings.Contains(line, "bytes=16") {
+			t.Errorf("Expected only the size of the payload, Got: %s", line)
+		}
+	}
+	if !strings.Contains(lines[1], "payload_sha256=") {
+		t.Errorf("Expected a fingerprint of the redacted payload, Got: %s", lines[1])
+	}
+}
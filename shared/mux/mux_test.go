@@ -0,0 +1,171 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sessionPair() (*Session, *Session) {
+	a, b := net.Pipe()
+	return Client(a), Server(b)
+}
+
+func TestStreamEcho(t *testing.T) {
+	client, server := sessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		stream, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.CloseWrite()
+	}()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	if stream.ID()%2 != 1 {
+		t.Errorf("Expected an odd stream id for the client, Got: %d", stream.ID())
+	}
+
+	stream.Write([]byte("hello"))
+	stream.CloseWrite()
+
+	reply, err := io.ReadAll(stream)
+	if err != nil || string(reply) != "hello" {
+		t.Errorf("Echo error! Expected: hello, Got: %q, %v", reply, err)
+	}
+}
+
+// Sends more than a full window on several streams at once, which only completes if
+// window updates flow back as the receiver reads
+func TestFlowControlManyStreams(t *testing.T) {
+	client, server := sessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	payload := make([]byte, 3*InitialWindow+17)
+	rand.Read(payload)
+
+	go func() {
+		for {
+			stream, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(stream, stream)
+				stream.CloseWrite()
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stream, err := client.OpenStream()
+			if err != nil {
+				t.Errorf("Error opening stream: %v", err)
+				return
+			}
+
+			go func() {
+				stream.Write(payload)
+				stream.CloseWrite()
+			}()
+
+			reply, err := io.ReadAll(stream)
+			if err != nil || !bytes.Equal(reply, payload) {
+				t.Errorf("Stream %d corrupted, read %d of %d bytes: %v", stream.ID(), len(reply), len(payload), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSessionCloseUnblocksStreams(t *testing.T) {
+	client, server := sessionPair()
+
+	accepted := make(chan *Stream)
+	go func() {
+		stream, _ := server.AcceptStream()
+		accepted <- stream
+	}()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	<-accepted
+
+	done := make(chan error)
+	go func() {
+		_, err := stream.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	server.Close()
+	if err := <-done; err != ErrSessionClosed {
+		t.Errorf("Expected ErrSessionClosed, Got: %v", err)
+	}
+	<-client.Closed()
+
+	if _, err := client.OpenStream(); err != ErrSessionClosed {
+		t.Errorf("Expected ErrSessionClosed opening a stream on a closed session, Got: %v", err)
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	client, server := sessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	accepted := make(chan *Stream)
+	go func() {
+		stream, _ := server.AcceptStream()
+		accepted <- stream
+	}()
+
+	stream, _ := client.OpenStream()
+	remote := <-accepted
+	stream.Reset()
+
+	if _, err := remote.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Errorf("Expected ErrStreamReset, Got: %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	client, server := sessionPair()
+	defer client.Close()
+	defer server.Close()
+
+	rtts := make(chan time.Duration, 1)
+	client.SetPongHandler(func(rtt time.Duration) {
+		rtts <- rtt
+	})
+
+	if err := client.Ping(); err != nil {
+		t.Fatalf("Error sending ping: %v", err)
+	}
+
+	select {
+	case rtt := <-rtts:
+		if rtt <= 0 {
+			t.Errorf("Expected a positive round trip time, Got: %s", rtt)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a pong")
+	}
+}
@@ -0,0 +1,400 @@
+/**
+ * mux
+ * This package multiplexes many independent streams over a single connection, in the
+ * spirit of yamux, so forwarding, messages and requests can share one authenticated TLS
+ * connection instead of paying for a handshake each.
+ *
+ * Every frame starts with a 12 byte header (all integers big endian):
+ *  uint8  version
+ *  uint8  type      data, window update, ping or go away
+ *  uint16 flags     SYN opens a stream, FIN half-closes it, RST aborts it
+ *  uint32 stream id odd for streams opened by the client, even for the server
+ *  uint32 length    payload size for data frames, window delta for window updates
+ *
+ * Each stream has its own receive window. A sender may only have as many unacknowledged
+ * bytes in flight as the window allows and the receiver grants more credit with window
+ * updates as the application reads, so one slow stream never stalls the others.
+ */
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	protoVersion uint8 = 0
+
+	typeData         uint8 = 0
+	typeWindowUpdate uint8 = 1
+	typePing         uint8 = 2
+	typeGoAway       uint8 = 3
+
+	flagSYN uint16 = 1 << 0
+	flagACK uint16 = 1 << 1
+	flagFIN uint16 = 1 << 2
+	flagRST uint16 = 1 << 3
+
+	headerSize = 12
+
+	// InitialWindow is the receive window every stream starts with
+	InitialWindow = 256 << 10
+
+	// maxDataFrame bounds the payload of a single data frame so streams interleave fairly
+	maxDataFrame = 32 << 10
+
+	acceptBacklog = 64
+)
+
+var (
+	ErrSessionClosed  = errors.New("mux: session closed")
+	ErrStreamClosed   = errors.New("mux: stream closed")
+	ErrStreamReset    = errors.New("mux: stream reset by peer")
+	ErrProtocol       = errors.New("mux: protocol error")
+	ErrStreamsExhaust = errors.New("mux: stream ids exhausted")
+)
+
+// Session multiplexes streams over a single connection
+type Session struct {
+	conn   io.ReadWriteCloser
+	client bool
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+
+	acceptCh chan *Stream
+
+	pingMu      sync.Mutex
+	nextPing    uint32
+	pings       map[uint32]time.Time
+	pongHandler func(rtt time.Duration)
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+/**
+ * Client
+ * Starts a session on the dialing side of a connection.
+ */
+func Client(conn io.ReadWriteCloser) *Session {
+	return newSession(conn, true)
+}
+
+/**
+ * Server
+ * Starts a session on the accepting side of a connection.
+ */
+func Server(conn io.ReadWriteCloser) *Session {
+	return newSession(conn, false)
+}
+
+func newSession(conn io.ReadWriteCloser, client bool) *Session {
+	s := &Session{
+		conn:     conn,
+		client:   client,
+		streams:  map[uint32]*Stream{},
+		acceptCh: make(chan *Stream, acceptBacklog),
+		pings:    map[uint32]time.Time{},
+		closed:   make(chan struct{}),
+	}
+	if client {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+	go s.recvLoop()
+	return s
+}
+
+/**
+ * OpenStream
+ * Opens a new stream to the other side of the session.
+ */
+func (s *Session) OpenStream() (*Stream, error) {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	if s.nextID >= 1<<32-2 {
+		s.mu.Unlock()
+		return nil, ErrStreamsExhaust
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeWindowUpdate, flagSYN, id, 0, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+/**
+ * AcceptStream
+ * Waits for the other side to open a stream.
+ */
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.acceptCh:
+		return stream, nil
+	case <-s.closed:
+		return nil, ErrSessionClosed
+	}
+}
+
+/**
+ * Ping
+ * Sends a ping to the other side without waiting for the answer, the round trip time
+ * is handed to the pong handler once it arrives.
+ */
+func (s *Session) Ping() error {
+	s.pingMu.Lock()
+	s.nextPing++
+	id := s.nextPing
+	s.pings[id] = time.Now()
+	// forget pings that were never answered so a dead peer can't grow the map
+	for pending, sent := range s.pings {
+		if time.Since(sent) > time.Minute {
+			delete(s.pings, pending)
+		}
+	}
+	s.pingMu.Unlock()
+
+	return s.writeFrame(typePing, flagSYN, 0, id, nil)
+}
+
+/**
+ * SetPongHandler
+ * Registers a function called with the round trip time of every answered ping.
+ */
+func (s *Session) SetPongHandler(handler func(rtt time.Duration)) {
+	s.pingMu.Lock()
+	s.pongHandler = handler
+	s.pingMu.Unlock()
+}
+
+/**
+ * Close
+ * Tells the other side we are going away, closes the connection and every stream.
+ */
+func (s *Session) Close() error {
+	s.writeFrame(typeGoAway, 0, 0, 0, nil)
+	s.shutdown(ErrSessionClosed)
+	return nil
+}
+
+/**
+ * Closed
+ * Returns a channel which is closed once the session has ended for any reason.
+ */
+func (s *Session) Closed() <-chan struct{} {
+	return s.closed
+}
+
+/**
+ * Err
+ * Returns the reason the session ended, or nil while it is still running.
+ */
+func (s *Session) Err() error {
+	select {
+	case <-s.closed:
+		return s.closeErr
+	default:
+		return nil
+	}
+}
+
+/**
+ * NumStreams
+ * Returns the number of streams currently open on the session.
+ */
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) shutdown(err error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = err
+		close(s.closed)
+		s.conn.Close()
+
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = map[uint32]*Stream{}
+		s.mu.Unlock()
+
+		for _, stream := range streams {
+			stream.notifyAll()
+		}
+	})
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// writeFrame serializes a header and payload into a single write so frames never interleave
+func (s *Session) writeFrame(typ uint8, flags uint16, id uint32, length uint32, payload []byte) error {
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	buf[0] = protoVersion
+	buf[1] = typ
+	binary.BigEndian.PutUint16(buf[2:4], flags)
+	binary.BigEndian.PutUint32(buf[4:8], id)
+	binary.BigEndian.PutUint32(buf[8:12], length)
+	buf = append(buf, payload...)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.isClosed() {
+		return ErrSessionClosed
+	}
+	if _, err := s.conn.Write(buf); err != nil {
+		s.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Session) recvLoop() {
+	var hdr [headerSize]byte
+	for {
+		if _, err := io.ReadFull(s.conn, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = ErrSessionClosed
+			}
+			s.shutdown(err)
+			return
+		}
+
+		if hdr[0] != protoVersion {
+			s.shutdown(ErrProtocol)
+			return
+		}
+		typ := hdr[1]
+		flags := binary.BigEndian.Uint16(hdr[2:4])
+		id := binary.BigEndian.Uint32(hdr[4:8])
+		length := binary.BigEndian.Uint32(hdr[8:12])
+
+		var err error
+		switch typ {
+		case typeData, typeWindowUpdate:
+			err = s.handleStreamFrame(typ, flags, id, length)
+		case typePing:
+			if flags&flagSYN != 0 {
+				// answer asynchronously so the read loop never blocks on a write
+				go s.writeFrame(typePing, flagACK, 0, length, nil)
+			} else if flags&flagACK != 0 {
+				s.handlePong(length)
+			}
+		case typeGoAway:
+			err = ErrSessionClosed
+		default:
+			err = ErrProtocol
+		}
+
+		if err != nil {
+			s.shutdown(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handlePong(id uint32) {
+	s.pingMu.Lock()
+	sent, ok := s.pings[id]
+	delete(s.pings, id)
+	handler := s.pongHandler
+	s.pingMu.Unlock()
+
+	if ok && handler != nil {
+		handler(time.Since(sent))
+	}
+}
+
+func (s *Session) handleStreamFrame(typ uint8, flags uint16, id uint32, length uint32) error {
+	stream, err := s.streamFor(flags, id)
+	if err != nil {
+		return err
+	}
+
+	if typ == typeData {
+		if length > InitialWindow {
+			return ErrProtocol
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			return err
+		}
+		if stream != nil {
+			if err := stream.receive(payload); err != nil {
+				return err
+			}
+		}
+	} else if stream != nil && length > 0 {
+		stream.grantSendWindow(length)
+	}
+
+	if stream != nil {
+		if flags&flagFIN != 0 {
+			stream.remoteClose()
+		}
+		if flags&flagRST != 0 {
+			stream.reset()
+		}
+	}
+	return nil
+}
+
+// streamFor finds the stream a frame belongs to, creating it when the frame opens it.
+// Frames for streams we already forgot about are dropped by returning a nil stream.
+func (s *Session) streamFor(flags uint16, id uint32) (*Stream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, ok := s.streams[id]
+	if flags&flagSYN == 0 {
+		return stream, nil
+	}
+
+	// the peer may only open ids of its own parity
+	if ok || id == 0 || (id%2 == 1) == s.client {
+		return nil, ErrProtocol
+	}
+
+	stream = newStream(s, id)
+	select {
+	case s.acceptCh <- stream:
+		s.streams[id] = stream
+		return stream, nil
+	default:
+		// nobody is accepting, refuse the stream rather than stall the session
+		go s.writeFrame(typeWindowUpdate, flagRST, id, 0, nil)
+		return nil, nil
+	}
+}
@@ -0,0 +1,260 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Stream is a single bidirectional byte stream within a Session
+type Stream struct {
+	id      uint32
+	session *Session
+
+	mu           sync.Mutex
+	recvBuf      bytes.Buffer
+	recvWindow   uint32 // bytes the peer may still send before it needs more credit
+	unacked      uint32 // bytes read by the application not yet returned to the peer
+	sendWindow   uint32 // bytes we may still send before the peer grants more credit
+	localClosed  bool   // we sent FIN, no more writes
+	readClosed   bool   // Close was called, incoming data is discarded
+	remoteClosed bool   // the peer sent FIN, reads return EOF once drained
+	wasReset     bool
+
+	recvNotify chan struct{}
+	sendNotify chan struct{}
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		session:    session,
+		recvWindow: InitialWindow,
+		sendWindow: InitialWindow,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+	}
+}
+
+/**
+ * ID
+ * Returns the stream id, odd for streams opened by the client and even for the server.
+ */
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+/**
+ * Read
+ * Reads data sent by the peer, returning io.EOF once the peer has closed its side
+ * and everything it sent has been consumed.
+ */
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.recvBuf.Len() > 0 {
+			n, _ := st.recvBuf.Read(p)
+			st.unacked += uint32(n)
+			var credit uint32
+			// hand credit back in batches instead of a window update per read
+			if st.unacked >= InitialWindow/2 && !st.remoteClosed {
+				credit = st.unacked
+				st.recvWindow += credit
+				st.unacked = 0
+			}
+			st.mu.Unlock()
+
+			if credit > 0 {
+				st.session.writeFrame(typeWindowUpdate, 0, st.id, credit, nil)
+			}
+			return n, nil
+		}
+
+		switch {
+		case st.wasReset:
+			st.mu.Unlock()
+			return 0, ErrStreamReset
+		case st.remoteClosed:
+			st.mu.Unlock()
+			return 0, io.EOF
+		case st.readClosed:
+			st.mu.Unlock()
+			return 0, ErrStreamClosed
+		}
+		st.mu.Unlock()
+
+		select {
+		case <-st.recvNotify:
+		case <-st.session.closed:
+			st.mu.Lock()
+			pending := st.recvBuf.Len()
+			st.mu.Unlock()
+			if pending == 0 {
+				return 0, ErrSessionClosed
+			}
+		}
+	}
+}
+
+/**
+ * Write
+ * Sends data to the peer, blocking while the peer's receive window is exhausted.
+ */
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mu.Lock()
+		switch {
+		case st.wasReset:
+			st.mu.Unlock()
+			return written, ErrStreamReset
+		case st.localClosed:
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+
+		if st.sendWindow == 0 {
+			st.mu.Unlock()
+			select {
+			case <-st.sendNotify:
+				continue
+			case <-st.session.closed:
+				return written, ErrSessionClosed
+			}
+		}
+
+		n := uint32(len(p) - written)
+		if n > st.sendWindow {
+			n = st.sendWindow
+		}
+		if n > maxDataFrame {
+			n = maxDataFrame
+		}
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(typeData, 0, st.id, n, p[written:written+int(n)]); err != nil {
+			return written, err
+		}
+		written += int(n)
+	}
+	return written, nil
+}
+
+/**
+ * CloseWrite
+ * Half-closes the stream, the peer reads EOF while we can keep reading its data.
+ */
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.localClosed || st.wasReset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	done := st.remoteClosed
+	st.mu.Unlock()
+
+	err := st.session.writeFrame(typeData, flagFIN, st.id, 0, nil)
+	if done {
+		st.session.removeStream(st.id)
+	}
+	return err
+}
+
+/**
+ * Close
+ * Closes both directions of the stream, anything the peer still sends is discarded.
+ */
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	st.readClosed = true
+	st.recvBuf.Reset()
+	st.mu.Unlock()
+	st.notifyAll()
+
+	return st.CloseWrite()
+}
+
+/**
+ * Reset
+ * Aborts the stream in both directions without waiting for the peer.
+ */
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	if st.wasReset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.wasReset = true
+	st.mu.Unlock()
+	st.notifyAll()
+	st.session.removeStream(st.id)
+
+	return st.session.writeFrame(typeWindowUpdate, flagRST, st.id, 0, nil)
+}
+
+// receive buffers data from the peer, failing if it overran the window we granted
+func (st *Stream) receive(payload []byte) error {
+	st.mu.Lock()
+	if uint32(len(payload)) > st.recvWindow {
+		st.mu.Unlock()
+		return ErrProtocol
+	}
+	st.recvWindow -= uint32(len(payload))
+
+	var credit uint32
+	if st.readClosed {
+		// nobody will read this, return the credit straight away
+		credit = uint32(len(payload))
+		st.recvWindow += credit
+	} else {
+		st.recvBuf.Write(payload)
+	}
+	st.mu.Unlock()
+
+	if credit > 0 {
+		go st.session.writeFrame(typeWindowUpdate, 0, st.id, credit, nil)
+	}
+	st.notify(st.recvNotify)
+	return nil
+}
+
+func (st *Stream) grantSendWindow(delta uint32) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.mu.Unlock()
+	st.notify(st.sendNotify)
+}
+
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	done := st.localClosed
+	st.mu.Unlock()
+	st.notify(st.recvNotify)
+
+	if done {
+		st.session.removeStream(st.id)
+	}
+}
+
+func (st *Stream) reset() {
+	st.mu.Lock()
+	st.wasReset = true
+	st.mu.Unlock()
+	st.notifyAll()
+	st.session.removeStream(st.id)
+}
+
+func (st *Stream) notifyAll() {
+	st.notify(st.recvNotify)
+	st.notify(st.sendNotify)
+}
+
+func (st *Stream) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
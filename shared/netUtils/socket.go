@@ -0,0 +1,48 @@
+package netUtils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrSocketInUse is returned by ListenPrivateSocket when another process is listening
+var ErrSocketInUse = errors.New("socket in use")
+
+/**
+ * ListenPrivateSocket
+ * Listens on the Unix socket at path, replacing a socket left behind by a process which
+ * didn't shut down cleanly. Only the current user may connect to the socket, and a
+ * directory other users could swap it out of is refused.
+ */
+func ListenPrivateSocket(path string) (net.Listener, error) {
+	dir, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	if dir.Mode().Perm()&0022 != 0 && dir.Mode()&os.ModeSticky == 0 {
+		return nil, fmt.Errorf("%s is writable by other users, choose another socket path", filepath.Dir(path))
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrSocketInUse, path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// create the socket without group and other permissions rather than changing them
+	// afterwards, which would leave a window for someone else to connect
+	umask := syscall.Umask(0177)
+	defer syscall.Umask(umask)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,93 @@
+/**
+ * netUtils
+ * This package provides helpers shared by the client and the server for carrying raw
+ * bytes between a plaintext socket and a TLS tunnel.
+ */
+package netUtils
+
+import (
+	"io"
+	"sync"
+)
+
+// closeWriter is implemented by *net.TCPConn and *tls.Conn
+type closeWriter interface {
+	CloseWrite() error
+}
+
+/**
+ * Splice
+ * Copies bytes in both directions between a and b until both sides are done, then
+ * closes both and returns the number of bytes carried each way. Reads from a are taken
+ * from aReader which allows callers to hand over a buffered reader that may already
+ * hold data read past a protocol frame.
+ *
+ * When one side finishes sending, the write half of the other side is closed so the
+ * peer sees EOF while data keeps flowing in the opposite direction. If a connection
+ * does not support half-close, or a copy fails, both connections are closed.
+ */
+func Splice(a io.ReadWriteCloser, aReader io.Reader, b io.ReadWriteCloser) (aToB int64, bToA int64) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			a.Close()
+			b.Close()
+		})
+	}
+	defer closeBoth()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		aToB = copyAndCloseWrite(b, aReader, closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		bToA = copyAndCloseWrite(a, b, closeBoth)
+	}()
+	wg.Wait()
+
+	return
+}
+
+// copyAndCloseWrite copies src into dst and then half-closes dst, falling back to
+// closing everything when that isn't possible
+func copyAndCloseWrite(dst io.Writer, src io.Reader, closeBoth func()) int64 {
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		closeBoth()
+		return n
+	}
+
+	if cw, ok := dst.(closeWriter); ok {
+		if cw.CloseWrite() == nil {
+			return n
+		}
+	}
+	closeBoth()
+	return n
+}
+
+// bufferedConn reads through a buffered reader while writing and closing the connection
+type bufferedConn struct {
+	io.Reader
+	conn io.ReadWriteCloser
+}
+
+func (b *bufferedConn) Write(p []byte) (int, error) {
+	return b.conn.Write(p)
+}
+
+func (b *bufferedConn) Close() error {
+	return b.conn.Close()
+}
+
+/**
+ * WithReader
+ * Returns conn with its reads taken from r, used when a buffered reader may already
+ * hold data that arrived after the frames consumed from it.
+ */
+func WithReader(conn io.ReadWriteCloser, r io.Reader) io.ReadWriteCloser {
+	return &bufferedConn{Reader: r, conn: conn}
+}
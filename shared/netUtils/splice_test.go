@@ -0,0 +1,63 @@
+package netUtils
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialed, <-accepted
+}
+
+func TestSpliceHalfClose(t *testing.T) {
+	client, proxyFront := tcpPair(t)
+	proxyBack, server := tcpPair(t)
+
+	type counts struct{ up, down int64 }
+	done := make(chan counts)
+	go func() {
+		up, down := Splice(proxyFront, proxyFront, proxyBack)
+		done <- counts{up, down}
+	}()
+
+	// the client finishes sending before the server replies
+	client.Write([]byte("request"))
+	client.(*net.TCPConn).CloseWrite()
+
+	request, err := io.ReadAll(server)
+	if err != nil || string(request) != "request" {
+		t.Fatalf("Expected the server to read the request, Got: %q, %v", request, err)
+	}
+
+	server.Write([]byte("a longer response"))
+	server.Close()
+
+	response, err := io.ReadAll(client)
+	if err != nil || string(response) != "a longer response" {
+		t.Fatalf("Expected the response to arrive after the half-close, Got: %q, %v", response, err)
+	}
+	client.Close()
+
+	c := <-done
+	if c.up != int64(len("request")) || c.down != int64(len("a longer response")) {
+		t.Errorf("Byte accounting error! Got: %d up, %d down", c.up, c.down)
+	}
+}
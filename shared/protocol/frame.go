@@ -0,0 +1,395 @@
+/**
+ * protocol
+ * This package describes the framing used by the client and the server once a TLS
+ * connection has been established. Every unit of data crossing the tunnel is a Frame:
+ * a type, a small set of string headers used as metadata and an opaque body.
+ *
+ * Wire format (all integers big endian):
+ *  uint8  frame type
+ *  uint16 header count, followed by each header as
+ *         uint16 key length, key bytes, uint16 value length, value bytes
+ *  uint32 body length, followed by the body bytes
+ */
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// FrameType identifies what a frame carries
+type FrameType uint8
+
+const (
+	// MessageFrame carries an application message from the client to the server, or from
+	// the server to a subscriber when it was published to a topic
+	MessageFrame FrameType = iota + 1
+	// AckFrame is sent by the server once it has processed a message carrying an id
+	AckFrame
+	// ErrorFrame reports a failure to the peer, the body holds a human readable reason. One
+	// carrying the id header of a message rejects that message, it must not be resent.
+	ErrorFrame
+	// OpenFrame asks the server to dial the target header and carry raw bytes to it
+	OpenFrame
+	// OpenedFrame confirms an OpenFrame, everything that follows on the connection is raw data
+	OpenedFrame
+	// ExposeFrame asks the server to listen on the port header and relay connections back
+	ExposeFrame
+	// ConnectFrame tells an exposing client that a connection with the id header is waiting
+	ConnectFrame
+	// AcceptFrame claims a waiting connection by id on a fresh tunnel, raw data follows
+	AcceptFrame
+	// MuxFrame upgrades the connection to a multiplexed session once confirmed with an
+	// OpenedFrame, every stream then starts with its own first frame
+	MuxFrame
+	// PingFrame asks the peer to prove it is alive, it must be answered with a PongFrame
+	PingFrame
+	// PongFrame answers a PingFrame, echoing its headers
+	PongFrame
+	// CallFrame asks the server to run the handler for the method header with the body as
+	// payload, it is answered by a ReplyFrame or an ErrorFrame with the same id header
+	CallFrame
+	// ReplyFrame carries the response to the CallFrame with the same id header
+	ReplyFrame
+	// PushFrame starts an upload of the filename header, with the size and sha256 headers
+	// describing the whole file. The server confirms with an OpenedFrame whose offset header
+	// says where to resume, then DataFrames follow.
+	PushFrame
+	// PullFrame asks for the filename header from the offset header on, the server
+	// confirms with an OpenedFrame carrying size and sha256 headers, then DataFrames follow
+	PullFrame
+	// DataFrame carries the next chunk of a transfer, an empty body ends the transfer
+	DataFrame
+	// SubscribeFrame asks for every message published to the topic header, the server
+	// confirms with an OpenedFrame and then sends them as MessageFrames
+	SubscribeFrame
+	// ReceiveFrame asks for the messages other clients send to us directly, the server
+	// confirms with an OpenedFrame and then sends them as MessageFrames
+	ReceiveFrame
+)
+
+// Well known header keys
+const (
+	HeaderFilename = "filename"
+	HeaderID       = "id"
+	HeaderTarget   = "target"
+	HeaderPort     = "port"
+	HeaderMethod   = "method"
+	HeaderSize     = "size"
+	HeaderSHA256   = "sha256"
+	HeaderOffset   = "offset"
+
+	// HeaderTopic publishes a message to a topic instead of handing it to the server, the
+	// server delivers it with HeaderFrom holding the publisher's identity and HeaderDropped
+	// counting the messages a slow subscriber missed before it
+	HeaderTopic   = "topic"
+	HeaderFrom    = "from"
+	HeaderDropped = "dropped"
+
+	// HeaderTo sends a message directly to the client with that identity, it is delivered
+	// the same way as a published message
+	HeaderTo = "to"
+
+	// HeaderHeartbeat is set on the first frame of a connection by clients which answer
+	// pings, its value is the interval at which the client itself will ping
+	HeaderHeartbeat = "heartbeat"
+	HeaderSent      = "sent"
+)
+
+// MaxBodySize is the largest body a frame may carry, larger payloads must be chunked
+const MaxBodySize = 16 << 20
+
+// ChunkSize is the preferred body size when splitting a stream into several frames
+const ChunkSize = 32 << 10
+
+var ErrBodyTooLarge = errors.New("protocol: frame body exceeds maximum size")
+
+// Frame is a single unit of data on the wire
+type Frame struct {
+	Type    FrameType
+	Headers map[string]string
+	Body    []byte
+}
+
+/**
+ * NewMessage
+ * Convenience constructor for a MessageFrame carrying the given body.
+ */
+func NewMessage(body []byte) *Frame {
+	return &Frame{
+		Type:    MessageFrame,
+		Headers: map[string]string{},
+		Body:    body,
+	}
+}
+
+/**
+ * NewAck
+ * Builds the acknowledgement for the message with the given id.
+ */
+func NewAck(id string) *Frame {
+	return &Frame{
+		Type:    AckFrame,
+		Headers: map[string]string{HeaderID: id},
+	}
+}
+
+/**
+ * NewError
+ * Builds an ErrorFrame describing err.
+ */
+func NewError(err error) *Frame {
+	return &Frame{
+		Type:    ErrorFrame,
+		Headers: map[string]string{},
+		Body:    []byte(err.Error()),
+	}
+}
+
+/**
+ * NewCall
+ * Builds a call of method with the given id and payload.
+ */
+func NewCall(id string, method string, payload []byte) *Frame {
+	return &Frame{
+		Type:    CallFrame,
+		Headers: map[string]string{HeaderID: id, HeaderMethod: method},
+		Body:    payload,
+	}
+}
+
+/**
+ * NewReply
+ * Builds the reply to the call with the given id.
+ */
+func NewReply(id string, payload []byte) *Frame {
+	return &Frame{
+		Type:    ReplyFrame,
+		Headers: map[string]string{HeaderID: id},
+		Body:    payload,
+	}
+}
+
+/**
+ * NewPing
+ * Builds a PingFrame stamped with the time it was created.
+ */
+func NewPing() *Frame {
+	return &Frame{
+		Type:    PingFrame,
+		Headers: map[string]string{HeaderSent: strconv.FormatInt(time.Now().UnixNano(), 10)},
+	}
+}
+
+/**
+ * NewPong
+ * Builds the answer to a PingFrame.
+ */
+func NewPong(ping *Frame) *Frame {
+	return &Frame{
+		Type:    PongFrame,
+		Headers: ping.Headers,
+	}
+}
+
+/**
+ * RTT
+ * Returns the round trip time of a PongFrame answering one of our pings.
+ */
+func (f *Frame) RTT() time.Duration {
+	sent, err := strconv.ParseInt(f.Header(HeaderSent), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Since(time.Unix(0, sent))
+}
+
+/**
+ * Header
+ * Returns the value of a header or the empty string if it was not set.
+ */
+func (f *Frame) Header(key string) string {
+	if f.Headers == nil {
+		return ""
+	}
+	return f.Headers[key]
+}
+
+/**
+ * SetHeader
+ * Sets a header value, allocating the header map if necessary.
+ */
+func (f *Frame) SetHeader(key string, value string) {
+	if f.Headers == nil {
+		f.Headers = map[string]string{}
+	}
+	f.Headers[key] = value
+}
+
+func (t FrameType) String() string {
+	switch t {
+	case MessageFrame:
+		return "message"
+	case AckFrame:
+		return "ack"
+	case ErrorFrame:
+		return "error"
+	case OpenFrame:
+		return "open"
+	case OpenedFrame:
+		return "opened"
+	case ExposeFrame:
+		return "expose"
+	case ConnectFrame:
+		return "connect"
+	case AcceptFrame:
+		return "accept"
+	case MuxFrame:
+		return "mux"
+	case PingFrame:
+		return "ping"
+	case PongFrame:
+		return "pong"
+	case CallFrame:
+		return "call"
+	case ReplyFrame:
+		return "reply"
+	case PushFrame:
+		return "push"
+	case PullFrame:
+		return "pull"
+	case DataFrame:
+		return "data"
+	case SubscribeFrame:
+		return "subscribe"
+	case ReceiveFrame:
+		return "receive"
+	default:
+		return fmt.Sprintf("frame(%d)", uint8(t))
+	}
+}
+
+/**
+ * WriteFrame
+ * Encodes a frame and writes it to w in a single call so that frames written from
+ * different goroutines do not interleave as long as the writer is serialized.
+ */
+func WriteFrame(w io.Writer, f *Frame) error {
+	if len(f.Body) > MaxBodySize {
+		return ErrBodyTooLarge
+	}
+	if len(f.Headers) > 0xffff {
+		return errors.New("protocol: too many headers")
+	}
+
+	size := 1 + 2 + 4 + len(f.Body)
+	for k, v := range f.Headers {
+		if len(k) > 0xffff || len(v) > 0xffff {
+			return fmt.Errorf("protocol: header %q is too long", k)
+		}
+		size += 4 + len(k) + len(v)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, byte(f.Type))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(f.Headers)))
+	for k, v := range f.Headers {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(k)))
+		buf = append(buf, k...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		buf = append(buf, v...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(f.Body)))
+	buf = append(buf, f.Body...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+/**
+ * ReadFrame
+ * Reads and decodes a single frame from r. io.EOF is returned untouched when the
+ * stream ends cleanly between frames, a truncated frame yields io.ErrUnexpectedEOF.
+ */
+func ReadFrame(r io.Reader) (*Frame, error) {
+	var typ [1]byte
+	if _, err := io.ReadFull(r, typ[:]); err != nil {
+		return nil, err
+	}
+
+	f := &Frame{Type: FrameType(typ[0])}
+
+	count, err := readUint16(r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	f.Headers = make(map[string]string, count)
+	for i := 0; i < int(count); i++ {
+		key, err := readString(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		value, err := readString(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		f.Headers[key] = value
+	}
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	bodyLen := binary.BigEndian.Uint32(lenBuf[:])
+	if bodyLen > MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	f.Body = make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, f.Body); err != nil {
+		return nil, unexpected(err)
+	}
+
+	return f, nil
+}
+
+/**
+ * NewReader
+ * Wraps a connection in a buffered reader, frames are small and numerous so reading
+ * them straight from a tls.Conn would cost a syscall per field.
+ */
+func NewReader(r io.Reader) *bufio.Reader {
+	return bufio.NewReaderSize(r, ChunkSize)
+}
+
+func readUint16(r io.Reader) (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+func readString(r io.Reader) (string, error) {
+	n, err := readUint16(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// unexpected converts a clean EOF in the middle of a frame into ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	cases := []*Frame{
+		NewMessage([]byte("some message")),
+		NewMessage(nil),
+		{
+			Type:    MessageFrame,
+			Headers: map[string]string{HeaderFilename: "/var/log/syslog", "empty": ""},
+			Body:    []byte("line one\nline two\n"),
+		},
+	}
+
+	var buf bytes.Buffer
+	for _, c := range cases {
+		if err := WriteFrame(&buf, c); err != nil {
+			t.Fatalf("Write frame error! %v", err)
+		}
+	}
+
+	for _, c := range cases {
+		f, err := ReadFrame(&buf)
+		if err != nil {
+			t.Fatalf("Read frame error! %v", err)
+		}
+		if f.Type != c.Type || !bytes.Equal(f.Body, c.Body) || len(f.Headers) != len(c.Headers) {
+			t.Errorf("Frame mismatch! Expected: %+v, Got: %+v", c, f)
+		}
+		for k, v := range c.Headers {
+			if f.Header(k) != v {
+				t.Errorf("Header mismatch! Key: %s, Expected: %s, Got: %s", k, v, f.Header(k))
+			}
+		}
+	}
+
+	if _, err := ReadFrame(&buf); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last frame, Got: %v", err)
+	}
+}
+
+func TestReadTruncatedFrame(t *testing.T) {
+	var buf bytes.Buffer
+	WriteFrame(&buf, NewMessage([]byte("truncated")))
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-3])
+
+	if _, err := ReadFrame(truncated); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, Got: %v", err)
+	}
+}
+
+func TestWriteOversizedFrame(t *testing.T) {
+	f := NewMessage(make([]byte, MaxBodySize+1))
+	if err := WriteFrame(io.Discard, f); err != ErrBodyTooLarge {
+		t.Errorf("Expected ErrBodyTooLarge, Got: %v", err)
+	}
+}
+
+func TestTransferRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), ChunkSize/4)
+
+	var wire bytes.Buffer
+	if sent, err := SendData(&wire, bytes.NewReader(data)); err != nil || sent != int64(len(data)) {
+		t.Fatalf("Send error! Sent: %d, %v", sent, err)
+	}
+
+	var out bytes.Buffer
+	received, err := ReceiveData(bytes.NewReader(wire.Bytes()), &out, int64(len(data)))
+	if err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("Transfer corrupted! Received: %d of %d bytes, %v", received, len(data), err)
+	}
+
+	// a transfer cut short reports how far it got
+	out.Reset()
+	received, err = ReceiveData(bytes.NewReader(wire.Bytes()[:ChunkSize+100]), &out, int64(len(data)))
+	if err == nil || received != ChunkSize {
+		t.Errorf("Expected an interrupted transfer after %d bytes, Got: %d, %v", ChunkSize, received, err)
+	}
+
+	if _, err := ReceiveData(bytes.NewReader(wire.Bytes()), &out, 10); err == nil {
+		t.Error("Expected more data than announced to be refused")
+	}
+}
@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+)
+
+/**
+ * SplitMessages
+ * Reads r until EOF and passes each line, or each chunk of up to ChunkSize when lines is
+ * false, to send as a MessageFrame. Every frame owns its body, so it may be held on to,
+ * e.g. until it is acknowledged, while the next one is read.
+ */
+func SplitMessages(r io.Reader, lines bool, send func(*Frame) error) error {
+	if lines {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, ChunkSize), MaxBodySize)
+		for scanner.Scan() {
+			// the scanner reuses its buffer for the next line
+			if err := send(NewMessage(append([]byte(nil), scanner.Bytes()...))); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	buf := make([]byte, ChunkSize)
+	for {
+		// a plain Read hands over whatever is available so piped input isn't held back
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sendErr := send(NewMessage(append([]byte(nil), buf[:n]...))); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
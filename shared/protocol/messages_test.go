@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// chunkReader hands over its chunks one Read at a time, like a pipe written in bursts
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestSplitMessagesKeepsQueuedBodies(t *testing.T) {
+	// the frames are held, as an outbox does until they are acknowledged, while the
+	// next ones are read
+	var queued []*Frame
+	queue := func(f *Frame) error {
+		queued = append(queued, f)
+		return nil
+	}
+
+	if err := SplitMessages(strings.NewReader("aaa\nbbb\nccc\n"), true, queue); err != nil {
+		t.Fatalf("Split error! %v", err)
+	}
+	if err := SplitMessages(&chunkReader{chunks: []string{"ddd", "eee"}}, false, queue); err != nil {
+		t.Fatalf("Split error! %v", err)
+	}
+
+	expected := []string{"aaa", "bbb", "ccc", "ddd", "eee"}
+	if len(queued) != len(expected) {
+		t.Fatalf("Expected %d messages, Got: %d", len(expected), len(queued))
+	}
+	for i, f := range queued {
+		if f.Type != MessageFrame || string(f.Body) != expected[i] {
+			t.Errorf("Message %d was overwritten! Expected: %q, Got: %q", i, expected[i], f.Body)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"fmt"
+	"io"
+)
+
+/**
+ * SendData
+ * Writes everything read from r to w as DataFrames of ChunkSize, followed by the empty
+ * DataFrame which ends a transfer. It returns the number of bytes sent.
+ */
+func SendData(w io.Writer, r io.Reader) (int64, error) {
+	buf := make([]byte, ChunkSize)
+	var sent int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := WriteFrame(w, &Frame{Type: DataFrame, Body: buf[:n]}); err != nil {
+				return sent, err
+			}
+			sent += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sent, WriteFrame(w, &Frame{Type: DataFrame})
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+}
+
+/**
+ * ReceiveData
+ * Reads DataFrames from r into w until the empty DataFrame ending the transfer, which
+ * must arrive after exactly expected bytes. It returns the number of bytes written, so
+ * an interrupted transfer knows how far it got.
+ */
+func ReceiveData(r io.Reader, w io.Writer, expected int64) (int64, error) {
+	var received int64
+	for {
+		frame, err := ReadFrame(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return received, err
+		}
+		if frame.Type != DataFrame {
+			return received, fmt.Errorf("protocol: unexpected %s frame during transfer", frame.Type)
+		}
+
+		if len(frame.Body) == 0 {
+			if received != expected {
+				return received, fmt.Errorf("protocol: transfer ended after %d of %d bytes", received, expected)
+			}
+			return received, nil
+		}
+		if received+int64(len(frame.Body)) > expected {
+			return received, fmt.Errorf("protocol: transfer exceeds the announced %d bytes", expected)
+		}
+
+		n, err := w.Write(frame.Body)
+		received += int64(n)
+		if err != nil {
+			return received, err
+		}
+	}
+}
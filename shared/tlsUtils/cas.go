@@ -0,0 +1,99 @@
+package tlsUtils
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/**
+ * LoadCertPool
+ * Builds a pool of the CAs in paths, each a PEM file or a directory of them. The pool
+ * starts from the operating system's CAs when paths holds cliUtils.SystemCAs.
+ */
+func LoadCertPool(paths []string) (*x509.CertPool, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no CA certificates configured")
+	}
+
+	certPool := x509.NewCertPool()
+	for _, path := range paths {
+		if path != cliUtils.SystemCAs {
+			continue
+		}
+		system, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the system's CAs: %v", err)
+		}
+		certPool = system
+	}
+
+	certs, err := ReadAllCertificates(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		certPool.AddCert(cert)
+	}
+	return certPool, nil
+}
+
+/**
+ * ReadAllCertificates
+ * Parses the certificates in every PEM file of paths, reading every .pem and .crt file of
+ * the directories among them. The system's CAs are left out.
+ */
+func ReadAllCertificates(paths []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, path := range paths {
+		if path == cliUtils.SystemCAs {
+			continue
+		}
+		files, err := certificateFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			found, err := ReadCertificates(file)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, found...)
+		}
+	}
+	return certs, nil
+}
+
+// certificateFiles lists the PEM files in a directory by name, or returns a file as it is
+func certificateFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if entry.IsDir() || strings.HasPrefix(name, ".") || ext != ".pem" && ext != ".crt" {
+			continue
+		}
+		files = append(files, filepath.Join(path, name))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no .pem or .crt files found", path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
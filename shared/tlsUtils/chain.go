@@ -0,0 +1,130 @@
+package tlsUtils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// keyPair locates a certificate and its key, and the intermediates presented with it
+type keyPair struct {
+	certPath  string
+	keyPath   string
+	chainPath string
+
+	// issuers are the CAs the presented chain must build to
+	issuers []string
+	// trimRoot leaves a self-signed root at the end of the chain out of the handshake
+	trimRoot bool
+}
+
+/**
+ * loadKeyPair
+ * Loads a certificate and its key, appends the intermediates in the chain file and makes sure
+ * the chain builds to one of the issuers. A chain out of order or missing an intermediate
+ * only found among the issuers is logged as a warning, since it still works for peers
+ * trusting the same CAs.
+ */
+func loadKeyPair(p keyPair) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(p.certPath, p.keyPath)
+	if err != nil {
+		return cert, err
+	}
+	if p.chainPath != "" {
+		intermediates, err := ReadCertificates(p.chainPath)
+		if err != nil {
+			return cert, err
+		}
+		for _, intermediate := range intermediates {
+			cert.Certificate = append(cert.Certificate, intermediate.Raw)
+		}
+	}
+
+	chain := make([]*x509.Certificate, 0, len(cert.Certificate))
+	for _, der := range cert.Certificate {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return cert, fmt.Errorf("%s: %v", p.certPath, err)
+		}
+		chain = append(chain, parsed)
+	}
+
+	if err := verifyChain(p, chain); err != nil {
+		return cert, err
+	}
+
+	if last := chain[len(chain)-1]; p.trimRoot && len(chain) > 1 && isSelfSigned(last) {
+		cert.Certificate = cert.Certificate[:len(cert.Certificate)-1]
+	}
+	return cert, nil
+}
+
+// verifyChain builds the presented chain to the issuers and warns about what peers may trip on
+func verifyChain(p keyPair, chain []*x509.Certificate) error {
+	leaf := chain[0]
+	for i := 0; i+1 < len(chain); i++ {
+		if chain[i].CheckSignatureFrom(chain[i+1]) != nil {
+			slog.Warn("certificate chain out of order, every certificate should be followed by its issuer",
+				"cert", p.certPath, "position", i+1, "subject", chain[i].Subject.String(),
+				"followed_by", chain[i+1].Subject.String())
+			break
+		}
+	}
+
+	roots, err := LoadCertPool(p.issuers)
+	if err != nil {
+		return err
+	}
+	presented := x509.NewCertPool()
+	for _, intermediate := range chain[1:] {
+		presented.AddCert(intermediate)
+	}
+	verified, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: presented,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		// expired certificates are reported by the readiness checks and metrics, they
+		// still load so the server can start and be renewed
+		slog.Warn("certificate chain expired", "cert", p.certPath, "error", err)
+		return nil
+	}
+	var unknown x509.UnknownAuthorityError
+	if errors.As(err, &unknown) {
+		last := chain[len(chain)-1]
+		return fmt.Errorf("%s doesn't chain to a configured CA, the chain ends at %s issued by %s, "+
+			"is an intermediate missing from the cert or cert-chain file?", p.certPath, last.Subject, last.Issuer)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", p.certPath, err)
+	}
+
+	// an intermediate found among the issuers rather than presented is only known to peers
+	// trusting it directly
+	for _, needed := range verified[0][1:] {
+		if !containsCertificate(chain, needed) && !isSelfSigned(needed) {
+			slog.Warn("intermediate missing from the certificate chain, peers which only trust the root will refuse it",
+				"cert", p.certPath, "intermediate", needed.Subject.String())
+		}
+	}
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func containsCertificate(chain []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range chain {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package tlsUtils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI is a root, an intermediate it signed and a leaf signed by the intermediate,
+// along with an unrelated root
+type testPKI struct {
+	dir                                string
+	root, intermediate, leaf, stranger *x509.Certificate
+	leafKey                            *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	p := &testPKI{dir: t.TempDir()}
+	var rootKey, intermediateKey *ecdsa.PrivateKey
+	p.root, rootKey = issue(t, "Test Root", nil, nil, true)
+	p.intermediate, intermediateKey = issue(t, "Test Intermediate", p.root, rootKey, true)
+	p.leaf, p.leafKey = issue(t, "server.test", p.intermediate, intermediateKey, false)
+	p.stranger, _ = issue(t, "Other Root", nil, nil, true)
+	return p
+}
+
+// issue creates a certificate signed by parent, or a self-signed one when parent is nil
+func issue(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{name}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// write stores certs as one PEM file named name and returns its path
+func (p *testPKI) write(t *testing.T, name string, certs ...*x509.Certificate) string {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// keyPair returns the leaf's key pair, the cert file holding certs and the chain file chain
+func (p *testPKI) keyPair(t *testing.T, certs []*x509.Certificate, chain []*x509.Certificate, issuers ...*x509.Certificate) keyPair {
+	der, err := x509.MarshalECPrivateKey(p.leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(p.dir, "leaf.key")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pair := keyPair{certPath: p.write(t, "leaf.crt", certs...), keyPath: keyPath}
+	if len(chain) > 0 {
+		pair.chainPath = p.write(t, "chain.pem", chain...)
+	}
+	for i, issuer := range issuers {
+		pair.issuers = append(pair.issuers, p.write(t, "issuer"+string(rune('0'+i))+".crt", issuer))
+	}
+	return pair
+}
+
+// captureWarnings collects what is logged while the test runs
+func captureWarnings(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestLoadKeyPairChain(t *testing.T) {
+	p := newTestPKI(t)
+	warnings := captureWarnings(t)
+
+	cert, err := loadKeyPair(p.keyPair(t, []*x509.Certificate{p.leaf}, []*x509.Certificate{p.intermediate}, p.root))
+	if err != nil {
+		t.Fatalf("Error loading a complete chain: %v", err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Errorf("Expected the leaf and the intermediate, Got: %d certificates", len(cert.Certificate))
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("Expected no warnings, Got: %s", warnings)
+	}
+}
+
+func TestLoadKeyPairOutOfOrder(t *testing.T) {
+	p := newTestPKI(t)
+	warnings := captureWarnings(t)
+
+	_, err := loadKeyPair(p.keyPair(t, []*x509.Certificate{p.leaf}, []*x509.Certificate{p.root, p.intermediate}, p.root))
+	if err != nil {
+		t.Fatalf("Expected a chain out of order to load, Got: %v", err)
+	}
+	if !strings.Contains(warnings.String(), "out of order") {
+		t.Errorf("Expected a warning about the order, Got: %q", warnings)
+	}
+}
+
+func TestLoadKeyPairMissingIntermediate(t *testing.T) {
+	p := newTestPKI(t)
+
+	_, err := loadKeyPair(p.keyPair(t, []*x509.Certificate{p.leaf}, nil, p.root))
+	if err == nil || !strings.Contains(err.Error(), "is an intermediate missing") {
+		t.Errorf("Expected an error about a missing intermediate, Got: %v", err)
+	}
+
+	// the intermediate is trusted directly, so the chain builds but peers trusting only
+	// the root won't accept it
+	warnings := captureWarnings(t)
+	_, err = loadKeyPair(p.keyPair(t, []*x509.Certificate{p.leaf}, nil, p.root, p.intermediate))
+	if err != nil {
+		t.Fatalf("Expected an intermediate among the issuers to be enough, Got: %v", err)
+	}
+	if !strings.Contains(warnings.String(), "intermediate missing") {
+		t.Errorf("Expected a warning about the missing intermediate, Got: %q", warnings)
+	}
+}
+
+func TestLoadKeyPairUnknownAuthority(t *testing.T) {
+	p := newTestPKI(t)
+
+	_, err := loadKeyPair(p.keyPair(t, []*x509.Certificate{p.leaf}, []*x509.Certificate{p.intermediate}, p.stranger))
+	if err == nil || !strings.Contains(err.Error(), "doesn't chain to a configured CA") {
+		t.Errorf("Expected an error about the CA, Got: %v", err)
+	}
+}
+
+func TestLoadKeyPairTrimRoot(t *testing.T) {
+	p := newTestPKI(t)
+	pair := p.keyPair(t, []*x509.Certificate{p.leaf, p.intermediate}, []*x509.Certificate{p.root}, p.root)
+
+	cert, err := loadKeyPair(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 3 {
+		t.Errorf("Expected the root to be kept, Got: %d certificates", len(cert.Certificate))
+	}
+
+	pair.trimRoot = true
+	cert, err = loadKeyPair(pair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.Certificate) != 2 || !bytes.Equal(cert.Certificate[1], p.intermediate.Raw) {
+		t.Errorf("Expected the root to be trimmed, Got: %d certificates", len(cert.Certificate))
+	}
+}
@@ -0,0 +1,156 @@
+package tlsUtils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/mattsurabian/go-tls/shared/cliUtils"
+	"sync/atomic"
+	"time"
+)
+
+// ServerTLSOptions locate the files a server listener presents and trusts, and set its TLS policy
+type ServerTLSOptions struct {
+	CertPath      string
+	KeyPath       string
+	ClientCAPaths []string
+
+	// ChainPath holds the intermediates presented after the certificate, which must build
+	// to one of the IssuerCAPaths
+	ChainPath     string
+	IssuerCAPaths []string
+	TrimChainRoot bool
+
+	MinVersion uint16
+	// CipherSuites apply to TLS 1.2, the TLS 1.3 suites can't be configured
+	CipherSuites []uint16
+
+	// SNICertificates are presented instead of the default certificate to clients asking
+	// for their names, clients asking for other names are refused when RejectUnknownNames
+	SNICertificates    []SNICertificate
+	RejectUnknownNames bool
+}
+
+// ServerCredentials are the certificate the server presents and the CAs it trusts to sign
+// client certificates. They can be reloaded from the configured files while the server
+// runs, connections already open keep the credentials they were made with.
+type ServerCredentials struct {
+	options ServerTLSOptions
+	current atomic.Pointer[loadedCredentials]
+}
+
+type loadedCredentials struct {
+	config   *tls.Config
+	notAfter time.Time
+}
+
+/**
+ * DefaultServerTLSOptions
+ * Returns the options set with server-tls-cert, server-tls-key, cert-chain and the CA options.
+ */
+func DefaultServerTLSOptions() ServerTLSOptions {
+	return ServerTLSOptions{
+		CertPath:      cliUtils.GetServerTLSCertPath(),
+		KeyPath:       cliUtils.GetServerTLSKeyPath(),
+		ClientCAPaths: cliUtils.GetClientCAs(),
+		ChainPath:     cliUtils.GetCertChainPath(),
+		IssuerCAPaths: cliUtils.GetServerCAs(),
+		TrimChainRoot: cliUtils.GetCertChainTrimRoot(),
+		MinVersion:    tls.VersionTLS12,
+		CipherSuites:  getCipherSuites(),
+	}
+}
+
+/**
+ * LoadServerCredentials
+ * Loads the files named by options.
+ */
+func LoadServerCredentials(options ServerTLSOptions) (*ServerCredentials, error) {
+	creds := &ServerCredentials{options: options}
+	if err := creds.Reload(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+/**
+ * Reload
+ * Reads the files again. The credentials in use are kept when any of them can't be loaded.
+ */
+func (c *ServerCredentials) Reload() error {
+	o := c.options
+	for _, path := range o.ClientCAPaths {
+		// identities are only common names, any public CA could issue one matching an ACL
+		if path == cliUtils.SystemCAs {
+			return fmt.Errorf("Cannot load server TLS certs or keys: the system's CAs can't verify client certificates, list the CAs signing them in client-ca")
+		}
+	}
+	pair := keyPair{
+		certPath:  o.CertPath,
+		keyPath:   o.KeyPath,
+		chainPath: o.ChainPath,
+		issuers:   o.IssuerCAPaths,
+		trimRoot:  o.TrimChainRoot,
+	}
+	cert, certPool, err := loadCertificates(pair, o.ClientCAPaths)
+	if err != nil {
+		return fmt.Errorf("Cannot load server TLS certs or keys: %v", err)
+	}
+	roots, err := ReadAllCertificates(o.ClientCAPaths)
+	if err != nil {
+		return fmt.Errorf("Cannot load server TLS certs or keys: %v", err)
+	}
+
+	selector, err := newCertificateSelector(&cert, o)
+	if err != nil {
+		return fmt.Errorf("Cannot load server TLS certs or keys: %v", err)
+	}
+
+	notAfter := cert.Leaf.NotAfter
+	for _, presented := range selector.certificates() {
+		if presented.Leaf.NotAfter.Before(notAfter) {
+			notAfter = presented.Leaf.NotAfter
+		}
+	}
+	for _, root := range roots {
+		if root.NotAfter.Before(notAfter) {
+			notAfter = root.NotAfter
+		}
+	}
+
+	c.current.Store(&loadedCredentials{
+		config: &tls.Config{
+			ClientCAs:              certPool,
+			ClientAuth:             tls.RequireAndVerifyClientCert,
+			GetCertificate:         selector.GetCertificate,
+			MinVersion:             o.MinVersion,
+			SessionTicketsDisabled: true,
+			CipherSuites:           o.CipherSuites,
+		},
+		notAfter: notAfter,
+	})
+	return nil
+}
+
+/**
+ * Options
+ * Returns the options the credentials are loaded with.
+ */
+func (c *ServerCredentials) Options() ServerTLSOptions {
+	return c.options
+}
+
+/**
+ * Config
+ * Returns the TLS configuration of the current credentials, for handshakes starting now.
+ */
+func (c *ServerCredentials) Config() *tls.Config {
+	return c.current.Load().config
+}
+
+/**
+ * NotAfter
+ * Returns when the first of the current server, SNI and root certificates expires.
+ */
+func (c *ServerCredentials) NotAfter() time.Time {
+	return c.current.Load().notAfter
+}
@@ -6,9 +6,12 @@
 package tlsUtils
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"github.com/mattsurabian/go-tls/shared/cliUtils"
 	"io/ioutil"
 	"net"
@@ -26,26 +29,16 @@ func getCipherSuites() []uint16 {
 
 /**
  * loadCertificates
- * Helper method to load a specified cert and key for TLS. Both the client and the server will
- * user this method.
+ * Helper method to load a specified cert and key for TLS, and the CA certificates in caPaths.
+ * Both the client and the server will user this method.
  */
-func loadCertificates(certPath string, keyPath string) (cert tls.Certificate, certPool *x509.CertPool, err error) {
-	caFile := cliUtils.GetRootCert()
-	cert, err = tls.LoadX509KeyPair(certPath, keyPath)
+func loadCertificates(pair keyPair, caPaths []string) (cert tls.Certificate, certPool *x509.CertPool, err error) {
+	cert, err = loadKeyPair(pair)
 	if err != nil {
 		return
 	}
 
-	pem, err := ioutil.ReadFile(caFile)
-	if err != nil {
-		return
-	}
-
-	certPool = x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(pem) {
-		panic("Failed appending certs")
-	}
-
+	certPool, err = LoadCertPool(caPaths)
 	return
 }
 
@@ -55,27 +48,20 @@ func loadCertificates(certPath string, keyPath string) (cert tls.Certificate, ce
  * The connection can be used to transmit data securely.
  */
 func GetClientTLSConnection() (conn *tls.Conn, err error) {
-	cert, certPool, err := loadCertificates(cliUtils.GetClientTLSCertPath(), cliUtils.GetClientTLSKeyPath())
+	config, err := GetClientTLSConfig()
 	if err != nil {
-		panic(errors.New("Cannot load client TLS certs or keys, maybe run config?"))
-	}
-
-	config := &tls.Config{
-		RootCAs:                certPool,
-		Certificates:           []tls.Certificate{cert},
-		MinVersion:             tls.VersionTLS12,
-		SessionTicketsDisabled: true,
-		ServerName:             cliUtils.GetRootName(),
-		CipherSuites:           getCipherSuites(),
+		return nil, fmt.Errorf("Cannot load client TLS certs or keys, maybe run config? %v", err)
 	}
 
 	conn, err = tls.Dial("tcp", cliUtils.GetHostAndPort(), config)
 	if err != nil {
+		err = explainVerifyError(err, config.ServerName)
 		return
 	}
 
 	err = conn.Handshake()
 	if err != nil {
+		err = explainVerifyError(err, config.ServerName)
 		return
 	}
 
@@ -83,30 +69,113 @@ func GetClientTLSConnection() (conn *tls.Conn, err error) {
 }
 
 /**
- * GetServerTLSListener
- * Helper method which is called by the server so it can listen for incomming client connections.
+ * GetClientTLSConfig
+ * Helper method returning the TLS configuration presenting the client certificate and
+ * trusting the server CAs, for connections to the server. The server's certificate must carry
+ * server-name among its DNS or IP names and, when root-name is set, be issued by that CA.
  */
-func GetServerTLSListener() (listener net.Listener) {
-
-	cert, certPool, err := loadCertificates(cliUtils.GetServerTLSCertPath(), cliUtils.GetServerTLSKeyPath())
+func GetClientTLSConfig() (*tls.Config, error) {
+	pair := keyPair{
+		certPath:  cliUtils.GetClientTLSCertPath(),
+		keyPath:   cliUtils.GetClientTLSKeyPath(),
+		chainPath: cliUtils.GetCertChainPath(),
+		issuers:   cliUtils.GetClientCAs(),
+		trimRoot:  cliUtils.GetCertChainTrimRoot(),
+	}
+	cert, certPool, err := loadCertificates(pair, cliUtils.GetServerCAs())
 	if err != nil {
-		panic(errors.New("Cannot load server TLS certs or keys, maybe run config?"))
+		return nil, err
 	}
 
 	config := &tls.Config{
-		ClientCAs:              certPool,
-		ClientAuth:             tls.RequireAndVerifyClientCert,
+		RootCAs:                certPool,
 		Certificates:           []tls.Certificate{cert},
 		MinVersion:             tls.VersionTLS12,
 		SessionTicketsDisabled: true,
-		CipherSuites:           []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		ServerName:             cliUtils.GetServerName(),
+		CipherSuites:           getCipherSuites(),
+	}
+	if rootName := cliUtils.GetRootName(); rootName != "" {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return checkIssuer(state.VerifiedChains, rootName)
+		}
+	}
+	return config, nil
+}
+
+/**
+ * GetServerTLSListener
+ * Helper method which is called by the server so it can listen for incomming client connections.
+ * Every handshake uses the credentials current at the time, so they can be reloaded while
+ * the server runs.
+ */
+func GetServerTLSListener(addr string, creds *ServerCredentials) (net.Listener, error) {
+	config := &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return creds.Config(), nil
+		},
+	}
+
+	return tls.Listen("tcp", addr, config)
+}
+
+/**
+ * PeerIdentity
+ * Returns the identity of the verified certificate presented by the other end of a TLS
+ * connection in the form "CN=<common name>", or the empty string if there is none.
+ * The handshake must have completed before calling this method.
+ */
+func PeerIdentity(conn net.Conn) string {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return ""
 	}
 
-	listener, err = tls.Listen("tcp", cliUtils.GetHostAndPort(), config)
+	return "CN=" + state.PeerCertificates[0].Subject.CommonName
+}
+
+/**
+ * Fingerprint
+ * Returns the SHA-256 fingerprint of a certificate as lowercase hex.
+ */
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+ * ReadCertificates
+ * Parses every certificate in a PEM file, in the order they appear.
+ */
+func ReadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		// If the server cannot open this listener, we panic, cause yo.
-		panic(err)
+		return nil, err
 	}
 
-	return
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return certs, nil
 }
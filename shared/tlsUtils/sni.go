@@ -0,0 +1,110 @@
+package tlsUtils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// SNICertificate is a certificate presented to clients asking for one of its names
+type SNICertificate struct {
+	// Names may start with a "*." wildcard matching one label, the DNS names in the
+	// certificate are used when there are none
+	Names     []string
+	CertPath  string
+	KeyPath   string
+	ChainPath string
+}
+
+// certificateSelector picks the certificate to present from the server name a client sent
+type certificateSelector struct {
+	byName        map[string]*tls.Certificate
+	fallback      *tls.Certificate
+	rejectUnknown bool
+}
+
+/**
+ * newCertificateSelector
+ * Loads the SNI certificates in options, their chains checked like the default
+ * certificate's. The names of the default certificate are served with it unless an SNI
+ * certificate claims them.
+ */
+func newCertificateSelector(fallback *tls.Certificate, options ServerTLSOptions) (*certificateSelector, error) {
+	s := &certificateSelector{
+		byName:        map[string]*tls.Certificate{},
+		fallback:      fallback,
+		rejectUnknown: options.RejectUnknownNames,
+	}
+
+	for _, sni := range options.SNICertificates {
+		cert, err := loadKeyPair(keyPair{
+			certPath:  sni.CertPath,
+			keyPath:   sni.KeyPath,
+			chainPath: sni.ChainPath,
+			issuers:   options.IssuerCAPaths,
+			trimRoot:  options.TrimChainRoot,
+		})
+		if err != nil {
+			return nil, err
+		}
+		names := sni.Names
+		if len(names) == 0 {
+			names = cert.Leaf.DNSNames
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("%s: no names given and the certificate has no DNS names", sni.CertPath)
+		}
+		for _, name := range names {
+			name = normalizeName(name)
+			if _, ok := s.byName[name]; ok {
+				return nil, fmt.Errorf("%s: %s is already served by another certificate", sni.CertPath, name)
+			}
+			s.byName[name] = &cert
+		}
+	}
+
+	for _, name := range fallback.Leaf.DNSNames {
+		if _, ok := s.byName[normalizeName(name)]; !ok {
+			s.byName[normalizeName(name)] = fallback
+		}
+	}
+	return s, nil
+}
+
+// certificates lists every certificate the selector may present
+func (s *certificateSelector) certificates() []*tls.Certificate {
+	certs := []*tls.Certificate{s.fallback}
+	seen := map[*tls.Certificate]bool{s.fallback: true}
+	for _, cert := range s.byName {
+		if !seen[cert] {
+			seen[cert] = true
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// GetCertificate matches the exact name first, then a wildcard covering it
+func (s *certificateSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeName(hello.ServerName)
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	if s.rejectUnknown {
+		if name == "" {
+			return nil, fmt.Errorf("unknown server name, the client sent none")
+		}
+		return nil, fmt.Errorf("unknown server name %q", hello.ServerName)
+	}
+	return s.fallback, nil
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,52 @@
+package tlsUtils
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/**
+ * checkIssuer
+ * Makes sure one of the CAs in the verified chains, the root or an intermediate, is named
+ * rootName, either by its common name or its whole subject.
+ */
+func checkIssuer(chains [][]*x509.Certificate, rootName string) error {
+	var issuers []string
+	for _, chain := range chains {
+		for _, ca := range chain[1:] {
+			if ca.Subject.CommonName == rootName || ca.Subject.String() == rootName {
+				return nil
+			}
+		}
+		issuers = append(issuers, chain[len(chain)-1].Subject.String())
+	}
+	return fmt.Errorf("the server's certificate was issued by %s, not by root-name %q",
+		strings.Join(issuers, " or "), rootName)
+}
+
+/**
+ * explainVerifyError
+ * Replaces a certificate name mismatch with an error saying which names the server's
+ * certificate holds, other errors are returned as they are.
+ */
+func explainVerifyError(err error, serverName string) error {
+	var hostErr x509.HostnameError
+	if !errors.As(err, &hostErr) {
+		return err
+	}
+
+	cert := hostErr.Certificate
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("the server's certificate has no DNS or IP names, only the common name %q which "+
+			"isn't used for verification, reissue it with a subject alternative name for %s", cert.Subject.CommonName, serverName)
+	}
+	return fmt.Errorf("the server's certificate is valid for %s, not %s, set server-name to the name the "+
+		"server is known by", strings.Join(names, ", "), serverName)
+}
@@ -0,0 +1,39 @@
+/**
+ * units
+ * This package parses the human friendly quantities used by options and configuration
+ * files, such as sizes written as 64MB.
+ */
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/**
+ * ParseSize
+ * Reads a positive size in bytes with an optional K, M or G suffix, which may be
+ * followed by a B, e.g. 512, 64K or 1GB.
+ */
+func ParseSize(value string) (int64, error) {
+	digits := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(digits, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(digits, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(digits, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		digits = digits[:len(digits)-1]
+	}
+
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,29 @@
+package units
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"512":  512,
+		"64K":  64 << 10,
+		"64kb": 64 << 10,
+		"64MB": 64 << 20,
+		" 2G ": 2 << 30,
+		"1gb":  1 << 30,
+		"100B": 100,
+	}
+	for value, expected := range cases {
+		size, err := ParseSize(value)
+		if err != nil || size != expected {
+			t.Errorf("Size error! Value: %q, Expected: %d, Got: %d, %v", value, expected, size, err)
+		}
+	}
+
+	for _, value := range []string{"", "MB", "0", "-1K", "ten", "1T"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("Expected %q to be refused", value)
+		}
+	}
+}